@@ -1,15 +1,29 @@
 package brain
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"lift/brain/journal"
 	"lift/brain/portman"
+	"lift/brain/portman/port"
+	"lift/cgroup"
 	"lift/gsmap"
 	"lift/gsmap/gs"
 	"lift/gsmap/gsinfo"
+	"lift/gsmap/gslog"
 	"lift/gsmap/gsparams"
+	"lift/gsmap/gsstate"
+	"lift/gsmap/monitor"
 	"lift/logger"
+	"lift/metrics"
 	"lift/setting"
 	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	libuuid "github.com/google/uuid"
@@ -19,30 +33,91 @@ type BrainParams struct {
 	GSExecutables    []setting.GSExecutable
 	GSListenAddress  string
 	GSMessageTimeout time.Duration
+	// base url of lift which gs connects to, like ws://127.0.0.1:9990
+	CallbackURL string
 
 	PortParams          portman.PortManParams
 	LoopInterval        time.Duration
 	MinimumWaitForClose time.Duration
+
+	JournalFile  string
+	RestoreGrace time.Duration
+
+	// limits of all processes, zero means unlimited
+	MaxProcesses int
+	MaxStarting  int
+	// suggested to clients when launch is rejected by limits
+	RetryAfter time.Duration
+
+	// zero interval disables sampling host resources,
+	// zero percent disables admission by the resource
+	ResourceSampleInterval time.Duration
+	HostMaxCPUPercent      float64
+	HostMaxMemoryPercent   float64
+
+	// empty disables cgroup limits
+	CgroupParent string
+
+	// records of exited processes kept, zero means DefaultExitHistorySize
+	ExitHistorySize int
+
+	// log files of processes, empty Dir logs output to lift log
+	GSLog gslog.Params
+
+	CrashLoop CrashLoopParams
 }
 
 type Brain struct {
-	params  *BrainParams
+	params  *atomic.Pointer[BrainParams]
 	portMan *portman.PortMan
+	journal *journal.Journal
 
 	gsMap   *gsmap.GSMap
+	metrics *metrics.LiftMetrics
 	logger  logger.Logger
 	ticker  *time.Ticker
-	closeCh chan bool
+	// closed by Close to stop goroutines of the brain
+	closeCh   chan bool
+	closeOnce sync.Once
+
+	launchMu      sync.Mutex
+	launchWaiters sync.Map
+
+	resources *atomic.Pointer[Resources]
+	// consecutive samples over MaxCPU by process id
+	cpuOver sync.Map
+	exits   *exitHistory
+	health  *healthTracker
 }
 
 var (
-	ErrorIndexOutOfRange = errors.New("index is out of range GSExecutables")
+	ErrorIndexOutOfRange    = errors.New("index is out of range GSExecutables")
+	ErrorExecutableNotFound = errors.New("no executable has the name")
+)
+
+// reasons of shutdown started by brain
+const (
+	ReasonFailed            = "failed"
+	ReasonNotEstablished    = "not established in time"
+	ReasonNoConnections     = "no connections"
+	ReasonDrained           = "drained"
+	ReasonMonitoringTimeout = "monitoring timed out"
+	ReasonExceededLimit     = "exceeded limit: "
 )
 
 func GenerateId() [16]byte {
 	return libuuid.New()
 }
 
+// GenerateSecret mints per launch secret which gs uses to connect.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func NewBrain(
 	params *BrainParams,
 	gsMap *gsmap.GSMap,
@@ -52,101 +127,417 @@ func NewBrain(
 	if err != nil {
 		return nil, err
 	}
+	if params.CgroupParent != "" {
+		if err := cgroup.Check(params.CgroupParent); err != nil {
+			return nil, err
+		}
+	}
 
 	b := &Brain{
-		params:  params,
+		params:  &atomic.Pointer[BrainParams]{},
 		portMan: pm,
 		gsMap:   gsMap,
+		metrics: metrics.NewLiftMetrics(),
 		logger:  logger,
 		ticker:  time.NewTicker(params.LoopInterval),
 		closeCh: make(chan bool),
+
+		resources: &atomic.Pointer[Resources]{},
+		exits:     &exitHistory{},
+		health:    newHealthTracker(),
+	}
+	b.params.Store(params)
+
+	if params.JournalFile != "" {
+		j, err := journal.NewJournal(params.JournalFile)
+		if err != nil {
+			return nil, err
+		}
+		b.journal = j
+		b.restore()
 	}
 
 	go b.brainMain()
+	go b.sampleResources()
+	go b.pruneLogs()
 
 	return b, nil
 }
 
+func (b *Brain) Params() *BrainParams {
+	return b.params.Load()
+}
+
 func (b *Brain) PortMan() *portman.PortMan {
 	return b.portMan
 }
 
 func (b *Brain) ExecutableList() []gsinfo.GSClass {
-	count := len(b.params.GSExecutables)
+	exes := b.Params().GSExecutables
+	count := len(exes)
 	list := make([]gsinfo.GSClass, 0, count)
 	for i := 0; i < count; i++ {
-		exe := b.params.GSExecutables[i]
+		exe := exes[i]
 		list = append(list, gsinfo.GSClass{
-			Name:           exe.ProcessName,
+			Name:           exe.Name,
+			ProcessName:    exe.ProcessName,
 			Index:          int64(i),
 			Capacity:       exe.ConnectionCapacity,
 			MaxBackfillSec: int64(exe.MaxBackfillSec),
+			WarmPoolSize:   int64(exe.WarmPoolSize),
 		})
 	}
 	return list
 }
 
-func (b *Brain) ValidIndex(idx int) bool {
-	return idx >= 0 && idx < len(b.params.GSExecutables)
+// Executable resolves the executable by its name,
+// index is also accepted for clients addressing by position.
+func (b *Brain) Executable(key string) (int, setting.GSExecutable, error) {
+	exes := b.Params().GSExecutables
+	if idx, err := strconv.Atoi(key); err == nil {
+		if idx < 0 || idx >= len(exes) {
+			return 0, setting.GSExecutable{}, ErrorIndexOutOfRange
+		}
+		return idx, exes[idx], nil
+	}
+
+	for i, exe := range exes {
+		if exe.Name == key {
+			return i, exe, nil
+		}
+	}
+	return 0, setting.GSExecutable{}, ErrorExecutableNotFound
 }
 
-func (b *Brain) Launch(idx int) (*gsinfo.GSPort, error) {
-	if idx < 0 || idx >= len(b.params.GSExecutables) {
-		return nil, ErrorIndexOutOfRange
+// Launch hands out an idle warm gs of the executable when there is one,
+// otherwise launches a new process that is allocated from the start.
+func (b *Brain) Launch(ctx context.Context, key string) (*gsinfo.GSPort, error) {
+	idx, exe, err := b.Executable(key)
+	if err != nil {
+		return nil, err
 	}
 
-	p, err := b.portMan.Next()
+	p, err := b.allocateWarm(exe.Name)
 	if err != nil {
 		return nil, err
 	}
+	if p != nil {
+		return p, nil
+	}
+
+	// warm processes established already, only new launches are refused
+	if !b.healthy(exe.Name) {
+		b.reject(exe, RejectUnhealthy)
+		return nil, ErrorExecutableUnhealthy
+	}
+
+	p, err = b.launchAdmitted(idx, exe, true)
+	if !limited(err) {
+		return p, err
+	}
+	if exe.LaunchWaitSec == 0 {
+		b.reject(exe, rejectReason(err))
+		return nil, err
+	}
+	return b.waitLaunch(ctx, idx, exe, err)
+}
 
-	param := gsparams.NewGSParams(
-		idx,
-		b.params.GSExecutables[idx].ProcessName,
-		GenerateId(),
-		b.params.GSListenAddress,
-		p,
-		b.params.GSMessageTimeout,
+func (b *Brain) allocateWarm(name string) (*gsinfo.GSPort, error) {
+	var found *gsinfo.GSPort
+	err := b.gsMap.Range(func(id string, gs *gs.GS) bool {
+		if gs.Executable() != name || gs.State() != gsstate.Ready || !gs.Allocate() {
+			return true
+		}
+
+		found = gs.GSPort()
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if found != nil {
+		b.logger.Debugf("warm process id: %s allocated", found.Id)
+		b.recordAllocated(found.Id)
+	}
+	return found, nil
+}
+
+func (b *Brain) launch(
+	idx int,
+	exe setting.GSExecutable,
+	allocate bool,
+) (*gsinfo.GSPort, error) {
+	uuid := GenerateId()
+	names := exe.PortNames()
+	ps, err := b.portMan.NextGroup(
+		b.portPool(exe),
+		libuuid.UUID(uuid).String(),
+		len(names),
 	)
-	gs, err := gs.NewGS(param, b.logger)
 	if err != nil {
 		return nil, err
 	}
 
+	secret, err := GenerateSecret()
+	if err != nil {
+		b.portMan.Return(ps...)
+		return nil, err
+	}
+
+	ports := make([]port.NamedPort, len(names))
+	for i, name := range names {
+		ports[i] = port.NamedPort{Name: name, Port: ps[i]}
+	}
+
+	param := b.newGSParams(idx, exe, uuid, ports, secret)
 	id := param.UuidString()
-	if gs.StartProcess(func() error {
-		if err := b.portMan.Return(p); err != nil {
+	cg, err := b.newCgroup(exe, id)
+	if err != nil {
+		b.portMan.Return(ps...)
+		return nil, err
+	}
+
+	output, err := b.newLogWriter(exe, id)
+	if err != nil {
+		b.portMan.Return(ps...)
+		removeCgroup(cg)
+		return nil, err
+	}
+
+	gs, err := gs.NewGS(param, cg, output, b.metrics, b.logger)
+	if err != nil {
+		b.portMan.Return(ps...)
+		removeCgroup(cg)
+		closeLogWriter(output)
+		return nil, err
+	}
+
+	if err := gs.StartProcess(b.onGSClosed(id, ps)); err != nil {
+		b.portMan.Return(ps...)
+		removeCgroup(cg)
+		closeLogWriter(output)
+		b.recordFailure(exe.Name, FailureLaunch)
+		return nil, err
+	}
+
+	if allocate {
+		gs.Allocate()
+	}
+	b.gsMap.Add(id, gs)
+	b.record(id, gs)
+	b.metrics.Launches.Inc(gs.MetricLabels()...)
+	return gs.GSPort(), nil
+}
+
+// newCgroup creates the cgroup of a process named by its id,
+// nil without limits.
+func (b *Brain) newCgroup(exe setting.GSExecutable, id string) (*cgroup.Cgroup, error) {
+	parent := b.Params().CgroupParent
+	limits := cgroup.Limits{
+		CPU:      exe.CgroupCPU,
+		MemoryMB: exe.CgroupMemoryMB,
+		Pids:     exe.CgroupPids,
+	}
+	if parent == "" || limits.Empty() {
+		return nil, nil
+	}
+	return cgroup.New(parent, id, limits)
+}
+
+// removeCgroup removes the cgroup of a process failed to start,
+// the process may have removed it already. processes left in it are killed.
+func removeCgroup(cg *cgroup.Cgroup) {
+	if cg != nil {
+		cg.Kill()
+		cg.Remove()
+	}
+}
+
+// portPool returns the pool of dedicated ranges of the executable, or shared one.
+// pools are fixed on start, so the pool is looked up by name rather than
+// by PortRanges which reload may change.
+func (b *Brain) portPool(exe setting.GSExecutable) string {
+	if _, ok := b.Params().PortParams.Pools[exe.Name]; ok {
+		return exe.Name
+	}
+	return portman.SharedPool
+}
+
+func (b *Brain) newGSParams(
+	idx int,
+	exe setting.GSExecutable,
+	uuid [16]byte,
+	ports []port.NamedPort,
+	secret string,
+) *gsparams.GSParams {
+	params := b.Params()
+	return gsparams.NewGSParams(
+		idx,
+		exe.Name,
+		exe.ProcessName,
+		uuid,
+		params.GSListenAddress,
+		ports,
+		secret,
+		params.CallbackURL,
+		&gsparams.Template{
+			Args:      exe.Args,
+			ExtraArgs: exe.ExtraArgs,
+			Env:       exe.Env,
+			Vars:      exe.Vars,
+			WorkDir:   exe.WorkDir,
+		},
+		params.GSMessageTimeout,
+		exe.ShutdownGrace(),
+		exe.TerminateTimeout(),
+	)
+}
+
+func (b *Brain) onGSClosed(id string, ps []port.Port) func() error {
+	return func() error {
+		if gs, err := b.gsMap.Item(id); err == nil {
+			if exit := gs.Exit(); exit != nil {
+				b.recordExit(exit)
+			}
+			b.observeExit(gs)
+		}
+		b.forget(id)
+		b.cpuOver.Delete(id)
+		if err := b.portMan.Return(ps...); err != nil {
 			return err
 		}
 
 		b.gsMap.Remove(id)
 		b.logger.Debugf(
-			"process id: %s removed from gsmap, returned port: %d",
-			id, p.Number(),
+			"process id: %s removed from gsmap, returned ports: %v",
+			id, ps,
 		)
 		return nil
-	}); err != nil {
-		return nil, err
 	}
+}
 
-	b.gsMap.Add(id, gs)
-	return &gsinfo.GSPort{
-		Id:   id,
-		Port: p.Number(),
-	}, nil
+// fillWarmPool launches idle processes until each executable
+// has as many idle processes as its WarmPoolSize.
+func (b *Brain) fillWarmPool(idle map[string]int) {
+	for i, exe := range b.Params().GSExecutables {
+		// quarantined executables are launched only as probes
+		if !b.healthy(exe.Name) {
+			continue
+		}
+		for n := idle[exe.Name]; n < exe.WarmPoolSize; n++ {
+			p, err := b.launchAdmitted(i, exe, false)
+			if limited(err) {
+				b.logger.Debugf("warm pool of executable: %s is limited, %s", exe.Name, err.Error())
+				break
+			}
+			if err != nil {
+				b.logger.Warnf(
+					"%s: failed to launch warm process for executable: %s",
+					err.Error(), exe.Name,
+				)
+				break
+			}
+			b.logger.Debugf("warm process id: %s launched", p.Id)
+		}
+	}
+}
+
+func (b *Brain) Shutdown(id string, reason string) error {
+	gs, err := b.gsMap.Item(id)
+	if err != nil {
+		return err
+	}
+
+	// counted once, brain may ask again until the process is gone
+	if gs.EndProcess(reason) {
+		b.logger.Debugf("brain start closing process id: %s, reason: %s", id, reason)
+		b.metrics.Shutdowns.Inc(append(gs.MetricLabels(), reason)...)
+	}
+	return nil
 }
 
-func (b *Brain) Shutdown(id string) error {
+// Kill kills the process without graceful shutdown.
+func (b *Brain) Kill(id string, reason string) error {
 	gs, err := b.gsMap.Item(id)
 	if err != nil {
 		return err
 	}
 
-	b.logger.Debugf("brain start closing process id: %s", id)
-	gs.EndProcess()
+	if gs.Kill(reason) {
+		b.logger.Warnf("brain killing process id: %s, reason: %s", id, reason)
+		b.metrics.Shutdowns.Inc(append(gs.MetricLabels(), reason)...)
+	}
 	return nil
 }
 
+// VerifySecret checks the secret of gs connecting to lift.
+func (b *Brain) VerifySecret(id string, secret string) bool {
+	gs, err := b.gsMap.Item(id)
+	if err != nil {
+		return false
+	}
+	return gs.VerifySecret(secret)
+}
+
+// SendCommand sends the command to the gs and waits for the reply,
+// zero timeout means GSMessageTimeout.
+func (b *Brain) SendCommand(
+	id string,
+	code uint8,
+	payload json.RawMessage,
+	timeout time.Duration,
+) (*monitor.MonitoringMessage, error) {
+	gs, err := b.gsMap.Item(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout <= 0 {
+		timeout = b.Params().GSMessageTimeout
+	}
+	b.logger.Debugf("brain sending command: %d to process id: %s", code, id)
+	return gs.SendCommand(code, payload, timeout)
+}
+
+// shutdownReason returns why the gs should be closed,
+// empty string means the gs should keep running.
+func (b *Brain) shutdownReason(info *gsinfo.GSInfo, now time.Time) string {
+	wait := b.Params().MinimumWaitForClose
+
+	switch info.State {
+	case gsstate.Failed:
+		return ReasonFailed
+	case gsstate.Starting:
+		if info.Restored {
+			wait = b.Params().RestoreGrace
+		}
+		if now.Sub(info.TimeStateChanged) >= wait {
+			return ReasonNotEstablished
+		}
+		return ""
+	case gsstate.Allocated:
+		if now.Sub(info.Summary.TimeAllocated) >= wait &&
+			info.Summary.ConnectionCount == 0 {
+			return ReasonNoConnections
+		}
+	case gsstate.Draining:
+		if info.Summary.ActiveSessionCount == 0 {
+			return ReasonDrained
+		}
+	}
+
+	// idle warm processes in Ready are waiting for clients,
+	// only monitoring is checked for them
+	if !info.Summary.TimeLastCommunicate.IsZero() &&
+		now.Sub(info.Summary.TimeLastCommunicate) >= wait {
+		return ReasonMonitoringTimeout
+	}
+	return ""
+}
+
+// recoverBrainMain restarts only brainMain, samplers started by NewBrain
+// keep running and must not be started again.
 func (b *Brain) recoverBrainMain() {
 	if r := recover(); r != nil {
 		b.logger.Warn("recovering brain main goroutine")
@@ -154,6 +545,13 @@ func (b *Brain) recoverBrainMain() {
 	}
 }
 
+// Close stops goroutines of the brain, processes are left as they are.
+func (b *Brain) Close() {
+	b.closeOnce.Do(func() {
+		close(b.closeCh)
+	})
+}
+
 func (b *Brain) brainMain() {
 	defer b.recoverBrainMain()
 
@@ -178,43 +576,67 @@ LOOP:
 			totalConn := 0
 			totalSession := 0
 			totalActiveSession := 0
+			totalIdle := 0
+			idle := make(map[string]int)
 			now := time.Now()
 			for i := 0; i < before; i++ {
 				info := infos[i]
 				totalConn += int(info.Summary.ConnectionCount)
 				totalSession += int(info.Summary.SessionCount)
 				totalActiveSession += int(info.Summary.ActiveSessionCount)
-				shutdown := info.Fatal
 
-				if !shutdown {
-					if !info.Summary.TimeLastCommunicate.IsZero() {
-						shutdown = now.Sub(info.Summary.TimeLastCommunicate) >=
-							b.params.MinimumWaitForClose
-					}
+				switch info.State {
+				case gsstate.ShuttingDown, gsstate.Exited:
+					after--
+					continue
 				}
 
-				if !shutdown {
-					if now.Sub(info.Summary.TimeStarted) >= b.params.MinimumWaitForClose {
-						shutdown = info.Summary.TimeEstablished.IsZero() ||
-							info.Summary.ConnectionCount == 0
+				if exceeded := b.exceededLimit(&info); exceeded != "" {
+					// sessions may finish on a busy process, a leaking one is killed
+					end := b.Kill
+					if exceeded == LimitCPU {
+						end = b.Shutdown
+					}
+					if err = end(info.Id, ReasonExceededLimit+exceeded); err != nil {
+						b.logger.Panicf(
+							"%s: this error means id was not found in map, the process will remain as zombie",
+							err.Error(),
+						)
 					}
+					after--
+					continue
 				}
 
-				if shutdown {
-					if err = b.Shutdown(info.Id); err != nil {
+				// a draining process is still checked for limits above
+				if info.Ending {
+					after--
+					continue
+				}
+
+				reason := b.shutdownReason(&info, now)
+				if reason != "" {
+					if err = b.Shutdown(info.Id, reason); err != nil {
 						b.logger.Panicf(
 							"%s: this error means id was not found in map, the process will remain as zombie",
 							err.Error(),
 						)
 					}
 					after--
+				} else if !info.Allocated &&
+					(info.State == gsstate.Starting || info.State == gsstate.Ready) {
+					idle[info.Executable]++
+					totalIdle++
 				}
 			}
 
+			b.fillWarmPool(idle)
+			b.probeUnhealthy()
+
 			b.logger.Infof(
-				"[Brain regular log] process before: %d, process after: %d, total connection %d, total session: %d, total active session %d",
+				"[Brain regular log] process before: %d, process after: %d, idle process: %d, total connection %d, total session: %d, total active session %d",
 				before,
 				after,
+				totalIdle,
 				totalConn,
 				totalSession,
 				totalActiveSession,
@@ -225,9 +647,10 @@ LOOP:
 	b.logger.Info("brain closed")
 }
 
-func (b *Brain) BackfillList(idx int) ([]gsinfo.GSBackfillPort, error) {
-	if idx < 0 || idx >= len(b.params.GSExecutables) {
-		return nil, ErrorIndexOutOfRange
+func (b *Brain) BackfillList(key string) ([]gsinfo.GSBackfillPort, error) {
+	_, exe, err := b.Executable(key)
+	if err != nil {
+		return nil, err
 	}
 
 	unsortedInfo, err := b.gsMap.UnsortedInfo()
@@ -237,18 +660,17 @@ func (b *Brain) BackfillList(idx int) ([]gsinfo.GSBackfillPort, error) {
 
 	total := len(unsortedInfo.Infos)
 	now := time.Now()
-	exe := b.params.GSExecutables[idx]
 	maxTimeBackfill := time.Second * time.Duration(exe.MaxBackfillSec)
 	temp := make([]int, 0, total)
 	for i := 0; i < total; i++ {
 		info := unsortedInfo.Infos[i]
-		if info.Index != idx {
+		if info.Executable != exe.Name || info.State != gsstate.Allocated {
 			continue
 		}
 		if exe.ConnectionCapacity-info.Summary.ConnectionCount <= 0 {
 			continue
 		}
-		if now.Sub(info.Summary.TimeStarted) >= maxTimeBackfill {
+		if now.Sub(info.Summary.TimeAllocated) >= maxTimeBackfill {
 			continue
 		}
 
@@ -262,7 +684,7 @@ func (b *Brain) BackfillList(idx int) ([]gsinfo.GSBackfillPort, error) {
 		roomJ := exe.ConnectionCapacity - infoJ.Summary.ConnectionCount
 
 		if roomI == roomJ {
-			return infoI.Summary.TimeStarted.Before(infoJ.Summary.TimeStarted)
+			return infoI.Summary.TimeAllocated.Before(infoJ.Summary.TimeAllocated)
 		} else {
 			return roomI < roomJ
 		}
@@ -274,10 +696,11 @@ func (b *Brain) BackfillList(idx int) ([]gsinfo.GSBackfillPort, error) {
 		info := unsortedInfo.Infos[temp[i]]
 		buff = append(buff, gsinfo.GSBackfillPort{
 			GsPort: gsinfo.GSPort{
-				Id:   info.Id,
-				Port: info.Port,
+				Id:    info.Id,
+				Port:  info.Port,
+				Ports: info.Ports,
 			},
-			Since:  info.Summary.TimeStarted,
+			Since:  info.Summary.TimeAllocated,
 			Active: info.Summary.ActiveSessionCount,
 		})
 	}
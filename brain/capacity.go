@@ -0,0 +1,167 @@
+package brain
+
+import (
+	"context"
+	"errors"
+	"lift/gsmap/gs"
+	"lift/gsmap/gsinfo"
+	"lift/gsmap/gsstate"
+	"lift/setting"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LaunchPollInterval = time.Millisecond * 100
+)
+
+var (
+	ErrorCapacityReached = errors.New("process limit is reached")
+	ErrorLaunchQueueFull = errors.New("launch queue is full")
+	ErrorLaunchCanceled  = errors.New("launch request was canceled while waiting in queue")
+)
+
+// reasons of rejected launches
+const (
+	RejectCapacity  = "capacity"
+	RejectQueueFull = "queue full"
+	RejectTimeout   = "queue timeout"
+	RejectCanceled  = "canceled"
+)
+
+type processCount struct {
+	total         int
+	starting      int
+	byExe         map[string]int
+	startingByExe map[string]int
+}
+
+// countProcesses counts processes which have not exited yet.
+func (b *Brain) countProcesses() (*processCount, error) {
+	c := &processCount{
+		byExe:         make(map[string]int),
+		startingByExe: make(map[string]int),
+	}
+	err := b.gsMap.Range(func(id string, gs *gs.GS) bool {
+		switch gs.State() {
+		case gsstate.Exited:
+			return true
+		case gsstate.Starting:
+			c.starting++
+			c.startingByExe[gs.Executable()]++
+		}
+		c.total++
+		c.byExe[gs.Executable()]++
+		return true
+	})
+	return c, err
+}
+
+// admit checks global and executable limits, zero means unlimited.
+func (b *Brain) admit(exe setting.GSExecutable) error {
+	c, err := b.countProcesses()
+	if err != nil {
+		return err
+	}
+
+	params := b.Params()
+	if over(c.total, params.MaxProcesses) ||
+		over(c.starting, params.MaxStarting) ||
+		over(c.byExe[exe.Name], exe.MaxProcesses) ||
+		over(c.startingByExe[exe.Name], exe.MaxStarting) {
+		return ErrorCapacityReached
+	}
+	return b.admitResources(exe, c)
+}
+
+// limited tells the launch is refused by limits and can wait in queue.
+func limited(err error) bool {
+	return err == ErrorCapacityReached || err == ErrorInsufficientResources
+}
+
+func rejectReason(err error) string {
+	if err == ErrorInsufficientResources {
+		return RejectResources
+	}
+	return RejectCapacity
+}
+
+func over(count int, limit int) bool {
+	return limit > 0 && count >= limit
+}
+
+// launchAdmitted launches when limits allow,
+// checking and launching are serialized so that limits are not exceeded.
+func (b *Brain) launchAdmitted(
+	idx int,
+	exe setting.GSExecutable,
+	allocate bool,
+) (*gsinfo.GSPort, error) {
+	b.launchMu.Lock()
+	defer b.launchMu.Unlock()
+
+	if err := b.admit(exe); err != nil {
+		return nil, err
+	}
+	return b.launch(idx, exe, allocate)
+}
+
+func (b *Brain) waiters(name string) *atomic.Int64 {
+	w, _ := b.launchWaiters.LoadOrStore(name, &atomic.Int64{})
+	return w.(*atomic.Int64)
+}
+
+// waitLaunch waits in the queue of the executable until a warm process
+// or room for a new process is available, up to LaunchWaitSec or until
+// ctx is done. cause is the limit which refused the launch first.
+func (b *Brain) waitLaunch(
+	ctx context.Context,
+	idx int,
+	exe setting.GSExecutable,
+	cause error,
+) (*gsinfo.GSPort, error) {
+	w := b.waiters(exe.Name)
+	if w.Add(1) > int64(exe.LaunchQueueSize) {
+		w.Add(-1)
+		b.reject(exe, RejectQueueFull)
+		return nil, ErrorLaunchQueueFull
+	}
+	defer w.Add(-1)
+
+	ticker := time.NewTicker(LaunchPollInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(time.Second * time.Duration(exe.LaunchWaitSec))
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the requester is gone, a process launched now would be idle
+			b.reject(exe, RejectCanceled)
+			return nil, ErrorLaunchCanceled
+		case <-timeout.C:
+			b.reject(exe, RejectTimeout)
+			return nil, cause
+		case <-ticker.C:
+			p, err := b.allocateWarm(exe.Name)
+			if err != nil || p != nil {
+				return p, err
+			}
+
+			if !b.healthy(exe.Name) {
+				b.reject(exe, RejectUnhealthy)
+				return nil, ErrorExecutableUnhealthy
+			}
+			p, err = b.launchAdmitted(idx, exe, true)
+			if !limited(err) {
+				return p, err
+			}
+			cause = err
+		}
+	}
+}
+
+func (b *Brain) reject(exe setting.GSExecutable, reason string) {
+	b.logger.Warnf("launch of executable: %s rejected, reason: %s", exe.Name, reason)
+	b.metrics.LaunchRejections.Inc(exe.Name, exe.ProcessName, reason)
+}
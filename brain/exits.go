@@ -0,0 +1,59 @@
+package brain
+
+import (
+	"lift/gsmap/gsinfo"
+	"sync"
+)
+
+const (
+	DefaultExitHistorySize = 200
+)
+
+// exitHistory keeps records of processes removed from gsmap, oldest first.
+type exitHistory struct {
+	mu      sync.Mutex
+	records []gsinfo.ExitInfo
+}
+
+func (h *exitHistory) add(e gsinfo.ExitInfo, size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = append(h.records, e)
+	if over := len(h.records) - size; over > 0 {
+		h.records = append(h.records[:0:0], h.records[over:]...)
+	}
+}
+
+func (h *exitHistory) newest(match func(*gsinfo.ExitInfo) bool, limit int) []gsinfo.ExitInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	found := make([]gsinfo.ExitInfo, 0)
+	for i := len(h.records) - 1; i >= 0; i-- {
+		if limit > 0 && len(found) == limit {
+			break
+		}
+		if match(&h.records[i]) {
+			found = append(found, h.records[i])
+		}
+	}
+	return found
+}
+
+func (b *Brain) recordExit(e *gsinfo.ExitInfo) {
+	size := b.Params().ExitHistorySize
+	if size <= 0 {
+		size = DefaultExitHistorySize
+	}
+	b.exits.add(*e, size)
+}
+
+// Exits returns records of exited processes newest first,
+// filtered by executable and class when not empty, zero limit means all.
+func (b *Brain) Exits(executable string, class string, limit int) []gsinfo.ExitInfo {
+	return b.exits.newest(func(e *gsinfo.ExitInfo) bool {
+		return (executable == "" || e.Executable == executable) &&
+			(class == "" || e.Class == class)
+	}, limit)
+}
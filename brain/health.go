@@ -0,0 +1,327 @@
+package brain
+
+import (
+	"errors"
+	"lift/gsmap/gs"
+	"lift/gsmap/gsinfo"
+	"lift/gsmap/gsstate"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorExecutableUnhealthy = errors.New("executable is quarantined after repeated failures")
+)
+
+const (
+	RejectUnhealthy = "unhealthy"
+)
+
+// reasons of failures counted for crash loop detection
+const (
+	FailureLaunch            = "failed to launch"
+	FailureNotEstablished    = "exited before established"
+	FailureFailed            = "failed"
+	FailureMonitoringTimeout = "monitoring timed out"
+	FailureCrashed           = "crashed"
+)
+
+type CrashLoopParams struct {
+	// failures within Window to quarantine an executable, zero disables
+	Threshold int
+	Window    time.Duration
+	// delay of the first probe, doubled on each failed probe
+	Backoff time.Duration
+	// zero means no cap
+	MaxBackoff time.Duration
+}
+
+// ExecutableHealth is crash loop state of an executable.
+type ExecutableHealth struct {
+	Name    string
+	Healthy bool
+	// failures within the window
+	Failures        int
+	LastFailure     string
+	TimeLastFailure time.Time
+
+	TimeQuarantined time.Time
+	BackoffSec      float64
+	TimeNextProbe   time.Time
+	// id of the probe process in flight
+	ProbeId string
+}
+
+type exeHealth struct {
+	failures    []time.Time
+	lastFailure string
+
+	unhealthy       bool
+	timeQuarantined time.Time
+	backoff         time.Duration
+	timeNextProbe   time.Time
+	probeId         string
+}
+
+// healthTracker keeps crash loop state by executable name,
+// executables without failures have no entry.
+type healthTracker struct {
+	mu   sync.Mutex
+	exes map[string]*exeHealth
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		exes: make(map[string]*exeHealth),
+	}
+}
+
+func (t *healthTracker) get(name string) *exeHealth {
+	h, ok := t.exes[name]
+	if !ok {
+		h = &exeHealth{}
+		t.exes[name] = h
+	}
+	return h
+}
+
+// failure tells why the exited gs counts as failure, empty string means not.
+// processes stopped by lift count only when lift stopped them for not
+// establishing or for not responding, others count when they reported
+// fatal, never established, or crashed.
+func failure(g *gs.GS) string {
+	info := g.Info()
+	for _, t := range info.Transitions {
+		// a broken connection alone is also seen when gs exits on its own
+		if t.To == gsstate.Failed &&
+			(t.Reason == gs.ReasonBrokenUuid || strings.HasPrefix(t.Reason, gs.ReasonFatalReported)) {
+			return FailureFailed
+		}
+	}
+
+	exit := g.Exit()
+	if exit != nil && exit.Initiated {
+		switch shutdownReason(info.Transitions) {
+		case ReasonNotEstablished:
+			return FailureNotEstablished
+		case ReasonMonitoringTimeout:
+			return FailureMonitoringTimeout
+		}
+		return ""
+	}
+	if info.Summary.TimeEstablished.IsZero() {
+		return FailureNotEstablished
+	}
+	if exit != nil && (exit.Class == gsinfo.ExitCrashed || exit.Class == gsinfo.ExitOOM) {
+		return FailureCrashed
+	}
+	return ""
+}
+
+// shutdownReason returns the reason lift shut the gs down with.
+func shutdownReason(transitions []gsstate.Transition) string {
+	for i := len(transitions) - 1; i >= 0; i-- {
+		if transitions[i].To == gsstate.ShuttingDown {
+			return transitions[i].Reason
+		}
+	}
+	return ""
+}
+
+func (b *Brain) healthy(name string) bool {
+	b.health.mu.Lock()
+	defer b.health.mu.Unlock()
+
+	h, ok := b.health.exes[name]
+	return !ok || !h.unhealthy
+}
+
+// observeExit counts the exited gs for crash loop detection,
+// or finishes the probe when it is the probe.
+func (b *Brain) observeExit(gs *gs.GS) {
+	name := gs.Executable()
+	reason := failure(gs)
+
+	b.health.mu.Lock()
+	h, ok := b.health.exes[name]
+	probe := ok && h.probeId != "" && h.probeId == gs.Id()
+	b.health.mu.Unlock()
+
+	switch {
+	case probe && reason == "":
+		b.liftQuarantine(name)
+	case probe:
+		b.probeFailed(name, reason)
+	case reason != "":
+		b.recordFailure(name, reason)
+	}
+}
+
+// recordFailure quarantines the executable when failures within
+// the window reach the threshold.
+func (b *Brain) recordFailure(name string, reason string) {
+	params := b.Params().CrashLoop
+	if params.Threshold <= 0 {
+		return
+	}
+
+	b.health.mu.Lock()
+	defer b.health.mu.Unlock()
+
+	now := time.Now()
+	h := b.health.get(name)
+	h.lastFailure = reason
+	h.failures = append(h.failures, now)
+	for len(h.failures) > 0 && now.Sub(h.failures[0]) > params.Window {
+		h.failures = h.failures[1:]
+	}
+	if h.unhealthy || len(h.failures) < params.Threshold {
+		return
+	}
+
+	h.unhealthy = true
+	h.timeQuarantined = now
+	h.backoff = params.Backoff
+	h.timeNextProbe = now.Add(h.backoff)
+	b.metrics.Quarantines.Inc(name)
+	b.logger.Warnf(
+		"executable: %s quarantined after %d failures in %s, last failure: %s, probing in %s",
+		name, len(h.failures), params.Window, reason, h.backoff,
+	)
+}
+
+func (b *Brain) probeFailed(name string, reason string) {
+	params := b.Params().CrashLoop
+
+	b.health.mu.Lock()
+	defer b.health.mu.Unlock()
+
+	h := b.health.get(name)
+	h.probeId = ""
+	h.lastFailure = reason
+	h.backoff *= 2
+	if params.MaxBackoff > 0 && h.backoff > params.MaxBackoff {
+		h.backoff = params.MaxBackoff
+	}
+	h.timeNextProbe = time.Now().Add(h.backoff)
+	b.logger.Warnf(
+		"probe of executable: %s failed, reason: %s, probing again in %s",
+		name, reason, h.backoff,
+	)
+}
+
+func (b *Brain) liftQuarantine(name string) {
+	b.health.mu.Lock()
+	defer b.health.mu.Unlock()
+
+	delete(b.health.exes, name)
+	b.logger.Infof("probe of executable: %s established, quarantine lifted", name)
+}
+
+// probeUnhealthy launches a warm process of each quarantined executable
+// whose backoff elapsed, and lifts quarantine when the probe establishes.
+func (b *Brain) probeUnhealthy() {
+	now := time.Now()
+	for i, exe := range b.Params().GSExecutables {
+		b.health.mu.Lock()
+		h, ok := b.health.exes[exe.Name]
+		if !ok || !h.unhealthy {
+			b.health.mu.Unlock()
+			continue
+		}
+		probeId := h.probeId
+		due := probeId == "" && !now.Before(h.timeNextProbe)
+		b.health.mu.Unlock()
+
+		if probeId != "" {
+			if g, err := b.gsMap.Item(probeId); err == nil && g.Established() {
+				b.liftQuarantine(exe.Name)
+			}
+			continue
+		}
+		if !due {
+			continue
+		}
+
+		p, err := b.launchAdmitted(i, exe, false)
+		if limited(err) {
+			continue
+		}
+		if err != nil {
+			b.probeFailed(exe.Name, FailureLaunch)
+			continue
+		}
+
+		b.health.mu.Lock()
+		b.health.get(exe.Name).probeId = p.Id
+		b.health.mu.Unlock()
+		b.logger.Infof("probe process id: %s of executable: %s launched", p.Id, exe.Name)
+	}
+}
+
+// UntilProbe returns how long until the quarantined executable is probed,
+// zero when it is healthy or its probe is in flight.
+func (b *Brain) UntilProbe(key string) time.Duration {
+	_, exe, err := b.Executable(key)
+	if err != nil {
+		return 0
+	}
+
+	b.health.mu.Lock()
+	defer b.health.mu.Unlock()
+
+	h, ok := b.health.exes[exe.Name]
+	if !ok || !h.unhealthy || h.probeId != "" {
+		return 0
+	}
+	return time.Until(h.timeNextProbe)
+}
+
+// ResetHealth lifts quarantine and forgets failures of the executable.
+func (b *Brain) ResetHealth(key string) (*ExecutableHealth, error) {
+	_, exe, err := b.Executable(key)
+	if err != nil {
+		return nil, err
+	}
+
+	b.health.mu.Lock()
+	delete(b.health.exes, exe.Name)
+	b.health.mu.Unlock()
+
+	b.logger.Infof("health of executable: %s reset", exe.Name)
+	return &ExecutableHealth{Name: exe.Name, Healthy: true}, nil
+}
+
+// Health returns crash loop state of executables in order of GSExecutables.
+func (b *Brain) Health() []ExecutableHealth {
+	window := b.Params().CrashLoop.Window
+	exes := b.Params().GSExecutables
+	list := make([]ExecutableHealth, 0, len(exes))
+
+	b.health.mu.Lock()
+	defer b.health.mu.Unlock()
+
+	now := time.Now()
+	for _, exe := range exes {
+		e := ExecutableHealth{Name: exe.Name, Healthy: true}
+		if h, ok := b.health.exes[exe.Name]; ok {
+			for _, t := range h.failures {
+				if now.Sub(t) <= window {
+					e.Failures++
+				}
+			}
+			if len(h.failures) > 0 {
+				e.TimeLastFailure = h.failures[len(h.failures)-1]
+			}
+			e.LastFailure = h.lastFailure
+			e.Healthy = !h.unhealthy
+			e.TimeQuarantined = h.timeQuarantined
+			e.BackoffSec = h.backoff.Seconds()
+			e.TimeNextProbe = h.timeNextProbe
+			e.ProbeId = h.probeId
+		}
+		list = append(list, e)
+	}
+	return list
+}
@@ -0,0 +1,133 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Port struct {
+	Name   string
+	Number uint16
+}
+
+type Entry struct {
+	Pid         int
+	Uuid        string
+	Index       int
+	Executable  string
+	ProcessName string
+	// the primary port, entries written before named ports have only this
+	Port  uint16
+	Ports []Port
+	// adopted process presents the same secret when connecting again,
+	// journal file is created readable only by owner
+	Secret      string
+	TimeStarted time.Time
+	Allocated   bool
+}
+
+// Journal persists running processes to local file
+// so that they can be adopted again after lift restarts.
+type Journal struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func NewJournal(path string) (*Journal, error) {
+	j := &Journal{
+		path:    path,
+		entries: make(map[string]Entry),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	list := []Entry{}
+	if err = json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	for _, e := range list {
+		j.entries[e.Uuid] = e
+	}
+	return j, nil
+}
+
+func (j *Journal) Entries() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	list := make([]Entry, 0, len(j.entries))
+	for _, e := range j.entries {
+		list = append(list, e)
+	}
+	return list
+}
+
+func (j *Journal) Put(e Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries[e.Uuid] = e
+	return j.flush()
+}
+
+func (j *Journal) SetAllocated(uuid string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e, ok := j.entries[uuid]
+	if !ok {
+		return nil
+	}
+	e.Allocated = true
+	j.entries[uuid] = e
+	return j.flush()
+}
+
+func (j *Journal) Remove(uuid string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.entries[uuid]; !ok {
+		return nil
+	}
+	delete(j.entries, uuid)
+	return j.flush()
+}
+
+// flush writes whole entries to temporary file and renames it,
+// so the journal is never left half written.
+func (j *Journal) flush() error {
+	list := make([]Entry, 0, len(j.entries))
+	for _, e := range j.entries {
+		list = append(list, e)
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
@@ -0,0 +1,63 @@
+package brain
+
+import (
+	"lift/gsmap/gslog"
+	"lift/setting"
+	"time"
+)
+
+const (
+	LogPruneInterval = time.Minute
+)
+
+// newLogWriter opens the log file of a process, nil when log files are disabled.
+// processes recorded to journal may outlive this lift, so they write
+// their output files by themselves instead of through pipes of this lift.
+func (b *Brain) newLogWriter(exe setting.GSExecutable, id string) (*gslog.Writer, error) {
+	params := b.Params().GSLog
+	if b.journal != nil {
+		return gslog.NewDetached(params, exe.Name, id)
+	}
+	if !params.Enabled() {
+		return nil, nil
+	}
+	return gslog.NewWriter(params, exe.Name, id)
+}
+
+// closeLogWriter closes the log file of a process failed to start.
+func closeLogWriter(w *gslog.Writer) {
+	if w != nil {
+		w.Close()
+	}
+}
+
+// pruneLogs deletes log files of exited processes older than Retention
+// until the brain is closed.
+func (b *Brain) pruneLogs() {
+	ticker := time.NewTicker(LogPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.closeCh:
+			return
+		case <-ticker.C:
+		}
+
+		params := b.Params().GSLog
+		if !params.Enabled() || params.Retention <= 0 {
+			continue
+		}
+
+		n, err := gslog.Prune(params.Dir, params.Retention, func(id string) bool {
+			_, err := b.gsMap.Item(id)
+			return err == nil
+		})
+		if err != nil {
+			b.logger.Warnf("%s: failed to prune log files", err.Error())
+		}
+		if n > 0 {
+			b.logger.Infof("%d log files pruned", n)
+		}
+	}
+}
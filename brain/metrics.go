@@ -0,0 +1,92 @@
+package brain
+
+import (
+	"io"
+	"lift/metrics"
+)
+
+// WriteMetrics writes gauges collected from current state
+// and metrics recorded on events in prometheus text format.
+func (b *Brain) WriteMetrics(w io.Writer) error {
+	processes := metrics.NewGaugeVec(
+		"lift_gs_processes",
+		"Number of game server processes by state.",
+		"executable", "process_name", "state",
+	)
+	connections := metrics.NewGaugeVec(
+		"lift_gs_connections",
+		"Connections reported by game servers.",
+		"executable", "process_name",
+	)
+	sessions := metrics.NewGaugeVec(
+		"lift_gs_sessions",
+		"Sessions reported by game servers.",
+		"executable", "process_name",
+	)
+	activeSessions := metrics.NewGaugeVec(
+		"lift_gs_active_sessions",
+		"Active sessions reported by game servers.",
+		"executable", "process_name",
+	)
+	healthy := metrics.NewGaugeVec(
+		"lift_executable_healthy",
+		"Whether the executable is not quarantined by crash loop detection.",
+		"executable",
+	)
+	ports := metrics.NewGaugeVec(
+		"lift_ports",
+		"Number of ports in port pool by status.",
+		"status",
+	)
+
+	for _, exe := range b.Params().GSExecutables {
+		labels := []string{exe.Name, exe.ProcessName}
+		connections.Set(0, labels...)
+		sessions.Set(0, labels...)
+		activeSessions.Set(0, labels...)
+	}
+
+	unsortedInfo, err := b.gsMap.UnsortedInfo()
+	if err != nil {
+		return err
+	}
+	for _, info := range unsortedInfo.Infos {
+		labels := []string{info.Executable, info.ProcessName}
+		processes.Add(1, append(labels, info.State.String())...)
+		connections.Add(float64(info.Summary.ConnectionCount), labels...)
+		sessions.Add(float64(info.Summary.SessionCount), labels...)
+		activeSessions.Add(float64(info.Summary.ActiveSessionCount), labels...)
+	}
+
+	for _, h := range b.Health() {
+		v := 0.0
+		if h.Healthy {
+			v = 1
+		}
+		healthy.Set(v, h.Name)
+	}
+
+	portInfo, err := b.portMan.Info()
+	if err != nil {
+		return err
+	}
+	ports.Set(float64(portInfo.CurrentCapacity), "free")
+	ports.Set(float64(portInfo.InUse), "used")
+	ports.Set(float64(len(portInfo.Quarantined)), "quarantined")
+	ports.Set(float64(len(portInfo.Cooling)), "cooling")
+
+	for _, c := range []metrics.Collector{
+		processes,
+		connections,
+		sessions,
+		activeSessions,
+		healthy,
+		ports,
+		b.metrics,
+	} {
+		if err = c.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
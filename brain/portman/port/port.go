@@ -29,3 +29,9 @@ func (p Port) String() string {
 func (p Port) Empty() bool {
 	return p.number == 0
 }
+
+// NamedPort is a port of a slot which executable declares, like game or query.
+type NamedPort struct {
+	Name string
+	Port Port
+}
@@ -2,113 +2,446 @@ package portman
 
 import (
 	"errors"
+	"net"
+	"sort"
 	"sync"
+	"time"
 
 	"lift/brain/portman/port"
+)
+
+const (
+	// pool of executables which have no dedicated ranges
+	SharedPool = ""
+)
 
-	libqueue "github.com/Workiva/go-datastructures/queue"
+// status of a port in allocation table
+const (
+	StatusFree        = "free"
+	StatusUsed        = "used"
+	StatusCooling     = "cooling"
+	StatusQuarantined = "quarantined"
 )
 
+// Range includes both From and To.
+type Range struct {
+	From uint16
+	To   uint16
+}
+
 type PortManParams struct {
-	InitialCapacity int64
-	StartFrom       uint16
+	Ranges []Range
+	// dedicated ranges by pool name
+	Pools map[string][]Range
+	// ports never handed out
+	Exclude []uint16
+
+	// probe binding before handing out a port on ProbeAddress,
+	// ports held by others are skipped for QuarantineDuration
+	ProbeTCP           bool
+	ProbeUDP           bool
+	ProbeAddress       string
+	QuarantineDuration time.Duration
+
+	// returned ports are not handed out until cooldown ends,
+	// so that packets to the previous match do not reach the next one
+	CooldownDuration time.Duration
 }
 
+// PortMan hands out free ports of each pool in the order they were returned.
 type PortMan struct {
 	params PortManParams
-	q      *libqueue.Queue
-	m      *sync.Map
+	total  int64
+
+	mu   sync.Mutex
+	free map[string][]port.Port
+	used map[uint16]bool
+	// pool name of each port
+	pool map[uint16]string
+	// ports found in use by others and when they are probed again
+	quarantined map[uint16]time.Time
+	// returned ports and when they become free
+	cooling map[uint16]time.Time
+
+	// uuid of gs holding the port, kept while cooling
+	owner map[uint16]string
+	// when status of the port changed
+	since             map[uint16]time.Time
+	probeFailures     map[uint16]int64
+	failedAllocations int64
+}
+
+// Allocation is a row of allocation table.
+type Allocation struct {
+	Number        uint16
+	Pool          string
+	Status        string
+	Owner         string
+	Since         time.Time
+	ProbeFailures int64
+}
+
+type PoolInfo struct {
+	Name  string
+	Total int64
+	Free  int64
 }
 
 type PortInfo struct {
 	CurrentCapacity int64
 	Peek            uint16
+	Total           int64
+	InUse           int64
+	Quarantined     []uint16
+	Cooling         []uint16
+	Pools           []PoolInfo
+	// allocations failed for not enough free ports
+	FailedAllocations int64
 }
 
 var (
 	ErrorInvalidPortZero = errors.New("invalid port number zero")
-	ErrorCastFail        = errors.New("failed to cast interface")
 	ErrorPortInUse       = errors.New("port is already in use")
 	ErrorPortNotUsed     = errors.New("port is not used")
+	ErrorPortNotManaged  = errors.New("port is not managed")
+	ErrorNotEnoughPorts  = errors.New("not enough free ports")
+	ErrorNoPorts         = errors.New("no ports in ranges")
+	ErrorInvalidRange    = errors.New("invalid port range")
+	ErrorPortOverlap     = errors.New("port is in more than one range")
+	ErrorPoolNotFound    = errors.New("port pool is not found")
+	ErrorInvalidStatus   = errors.New("status should be free, used, cooling or quarantined")
 )
 
 func NewPortMan(params PortManParams) (*PortMan, error) {
-	if params.InitialCapacity == 0 {
-		return nil, errors.New("zero capacity")
-	}
-	if params.StartFrom == 0 {
-		return nil, ErrorInvalidPortZero
+	excluded := make(map[uint16]bool, len(params.Exclude))
+	for _, n := range params.Exclude {
+		excluded[n] = true
 	}
 
-	q := libqueue.New(params.InitialCapacity)
-	m := &sync.Map{}
+	pools := map[string][]Range{SharedPool: params.Ranges}
+	for name, ranges := range params.Pools {
+		pools[name] = ranges
+	}
 
-	pn := params.StartFrom
-	var err error
-	for i, count := int64(0), params.InitialCapacity; i < count; i++ {
-		p := port.NewPort(pn)
-		if err = q.Put(p); err != nil {
-			return nil, err
+	pm := &PortMan{
+		params:        params,
+		free:          make(map[string][]port.Port, len(pools)),
+		used:          make(map[uint16]bool),
+		pool:          make(map[uint16]string),
+		quarantined:   make(map[uint16]time.Time),
+		cooling:       make(map[uint16]time.Time),
+		owner:         make(map[uint16]string),
+		since:         make(map[uint16]time.Time),
+		probeFailures: make(map[uint16]int64),
+	}
+	now := time.Now()
+	for name, ranges := range pools {
+		free := make([]port.Port, 0)
+		for _, r := range ranges {
+			if r.From == 0 || r.From > r.To {
+				return nil, ErrorInvalidRange
+			}
+			// int avoids wrapping around after 65535
+			for n := int(r.From); n <= int(r.To); n++ {
+				pn := uint16(n)
+				if excluded[pn] {
+					continue
+				}
+				if _, ok := pm.used[pn]; ok {
+					return nil, ErrorPortOverlap
+				}
+				pm.used[pn] = false
+				pm.pool[pn] = name
+				pm.since[pn] = now
+				free = append(free, port.NewPort(pn))
+			}
 		}
-		m.Store(pn, false)
-		pn++
+		pm.free[name] = free
 	}
 
-	return &PortMan{
-		q:      q,
-		m:      m,
-		params: params,
-	}, nil
+	pm.total = int64(len(pm.used))
+	if pm.total == 0 {
+		return nil, ErrorNoPorts
+	}
+	return pm, nil
 }
 
 func (pm *PortMan) Info() (PortInfo, error) {
-	i, err := pm.q.Peek()
-	if err != nil {
-		return PortInfo{
-			CurrentCapacity: 0,
-			Peek:            0,
-		}, nil
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.release(time.Now())
+
+	inUse := int64(0)
+	for _, u := range pm.used {
+		if u {
+			inUse++
+		}
 	}
 
-	peek, ok := i.(port.Port)
-	if !ok {
-		return PortInfo{}, ErrorCastFail
+	peek := uint16(0)
+	if shared := pm.free[SharedPool]; len(shared) > 0 {
+		peek = shared[0].Number()
+	}
+
+	totals := make(map[string]int64, len(pm.free))
+	for _, name := range pm.pool {
+		totals[name]++
+	}
+	free := int64(0)
+	pools := make([]PoolInfo, 0, len(pm.free))
+	for name, ports := range pm.free {
+		free += int64(len(ports))
+		pools = append(pools, PoolInfo{
+			Name:  name,
+			Total: totals[name],
+			Free:  int64(len(ports)),
+		})
 	}
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].Name < pools[j].Name
+	})
 
 	return PortInfo{
-		CurrentCapacity: pm.q.Len(),
-		Peek:            peek.Number(),
+		CurrentCapacity:   free,
+		Peek:              peek,
+		Total:             pm.total,
+		InUse:             inUse,
+		Quarantined:       sortedNumbers(pm.quarantined),
+		Cooling:           sortedNumbers(pm.cooling),
+		Pools:             pools,
+		FailedAllocations: pm.failedAllocations,
 	}, nil
 }
 
-func (pm *PortMan) Next() (port.Port, error) {
-	i, err := pm.q.Get(1)
+func (pm *PortMan) Next(owner string) (port.Port, error) {
+	ports, err := pm.NextGroup(SharedPool, owner, 1)
 	if err != nil {
 		return port.Port{}, err
 	}
+	return ports[0], nil
+}
+
+// NextGroup takes n ports of the pool at once,
+// none is taken when not enough are free.
+// ports which fail probing are quarantined and skipped.
+func (pm *PortMan) NextGroup(pool string, owner string, n int) ([]port.Port, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if _, ok := pm.free[pool]; !ok {
+		return nil, ErrorPoolNotFound
+	}
+
+	now := time.Now()
+	pm.release(now)
+	free := pm.free[pool]
 
-	p, ok := i[0].(port.Port)
-	if !ok {
-		return port.Port{}, ErrorCastFail
+	ports := make([]port.Port, 0, n)
+	for len(ports) < n && len(free) > 0 {
+		p := free[0]
+		free = free[1:]
+		if !pm.probe(p) {
+			pm.quarantined[p.Number()] = now.Add(pm.params.QuarantineDuration)
+			pm.since[p.Number()] = now
+			pm.probeFailures[p.Number()]++
+			continue
+		}
+		ports = append(ports, p)
 	}
 
-	ok = pm.m.CompareAndSwap(p.Number(), false, true)
-	if !ok {
-		return port.Port{}, ErrorPortInUse
+	if len(ports) < n {
+		// probed ports go back to the head in the same order
+		pm.free[pool] = append(ports, free...)
+		pm.failedAllocations++
+		return nil, ErrorNotEnoughPorts
 	}
 
-	return p, nil
+	pm.free[pool] = free
+	for _, p := range ports {
+		pm.use(p, owner, now)
+	}
+	return ports, nil
 }
 
-func (pm *PortMan) Return(p port.Port) error {
-	if p.Empty() {
-		return ErrorInvalidPortZero
+// probe checks nothing else binds the port.
+func (pm *PortMan) probe(p port.Port) bool {
+	addr := net.JoinHostPort(pm.params.ProbeAddress, p.String())
+	if pm.params.ProbeTCP {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return false
+		}
+		l.Close()
+	}
+	if pm.params.ProbeUDP {
+		c, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		c.Close()
 	}
+	return true
+}
+
+// release puts ports back to free ports when their quarantine or cooldown ends.
+func (pm *PortMan) release(now time.Time) {
+	pm.releaseExpired(pm.cooling, now)
+	pm.releaseExpired(pm.quarantined, now)
+}
 
-	ok := pm.m.CompareAndSwap(p.Number(), true, false)
-	if !ok {
-		return ErrorPortNotUsed
+// releaseExpired frees ports in the order they expired.
+func (pm *PortMan) releaseExpired(m map[uint16]time.Time, now time.Time) {
+	expired := make([]uint16, 0)
+	for n, until := range m {
+		if !now.Before(until) {
+			expired = append(expired, n)
+		}
 	}
+	sort.Slice(expired, func(i, j int) bool {
+		if m[expired[i]].Equal(m[expired[j]]) {
+			return expired[i] < expired[j]
+		}
+		return m[expired[i]].Before(m[expired[j]])
+	})
 
-	return pm.q.Put(p)
+	for _, n := range expired {
+		delete(m, n)
+		delete(pm.owner, n)
+		pm.since[n] = now
+		pm.pushFree(port.NewPort(n))
+	}
+}
+
+func (pm *PortMan) pushFree(p port.Port) {
+	name := pm.pool[p.Number()]
+	pm.free[name] = append(pm.free[name], p)
+}
+
+func sortedNumbers(m map[uint16]time.Time) []uint16 {
+	numbers := make([]uint16, 0, len(m))
+	for n := range m {
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] < numbers[j]
+	})
+	return numbers
+}
+
+// Reserve marks ports as used out of order,
+// for ports held by processes which are adopted after restart.
+func (pm *PortMan) Reserve(owner string, ports ...port.Port) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, p := range ports {
+		if p.Empty() {
+			return ErrorInvalidPortZero
+		}
+		used, ok := pm.used[p.Number()]
+		if !ok {
+			return ErrorPortNotManaged
+		}
+		if used {
+			return ErrorPortInUse
+		}
+	}
+
+	now := time.Now()
+	for _, p := range ports {
+		pm.use(p, owner, now)
+		pm.removeFree(p)
+		delete(pm.quarantined, p.Number())
+		delete(pm.cooling, p.Number())
+	}
+	return nil
+}
+
+func (pm *PortMan) use(p port.Port, owner string, now time.Time) {
+	pm.used[p.Number()] = true
+	pm.owner[p.Number()] = owner
+	pm.since[p.Number()] = now
+}
+
+func (pm *PortMan) removeFree(p port.Port) {
+	name := pm.pool[p.Number()]
+	free := pm.free[name]
+	for i, f := range free {
+		if f == p {
+			pm.free[name] = append(free[:i], free[i+1:]...)
+			return
+		}
+	}
+}
+
+// Return puts ports back to the end of free ports after cooldown.
+func (pm *PortMan) Return(ports ...port.Port) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	now := time.Now()
+	until := now.Add(pm.params.CooldownDuration)
+	var err error
+	for _, p := range ports {
+		if p.Empty() {
+			err = ErrorInvalidPortZero
+			continue
+		}
+		if !pm.used[p.Number()] {
+			err = ErrorPortNotUsed
+			continue
+		}
+
+		pm.used[p.Number()] = false
+		pm.since[p.Number()] = now
+		if pm.params.CooldownDuration > 0 {
+			pm.cooling[p.Number()] = until
+		} else {
+			delete(pm.owner, p.Number())
+			pm.pushFree(p)
+		}
+	}
+	return err
+}
+
+// Allocations returns allocation table sorted by port number,
+// empty status means every port.
+func (pm *PortMan) Allocations(status string) ([]Allocation, error) {
+	switch status {
+	case "", StatusFree, StatusUsed, StatusCooling, StatusQuarantined:
+	default:
+		return nil, ErrorInvalidStatus
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.release(time.Now())
+
+	list := make([]Allocation, 0)
+	for n, used := range pm.used {
+		s := StatusFree
+		if used {
+			s = StatusUsed
+		} else if _, ok := pm.cooling[n]; ok {
+			s = StatusCooling
+		} else if _, ok := pm.quarantined[n]; ok {
+			s = StatusQuarantined
+		}
+		if status != "" && status != s {
+			continue
+		}
+
+		list = append(list, Allocation{
+			Number:        n,
+			Pool:          pm.pool[n],
+			Status:        s,
+			Owner:         pm.owner[n],
+			Since:         pm.since[n],
+			ProbeFailures: pm.probeFailures[n],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Number < list[j].Number
+	})
+	return list, nil
 }
@@ -0,0 +1,285 @@
+package portman
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"lift/brain/portman/port"
+)
+
+func newPortMan(t *testing.T, params PortManParams) *PortMan {
+	t.Helper()
+	pm, err := NewPortMan(params)
+	if err != nil {
+		t.Fatalf("NewPortMan: %v", err)
+	}
+	return pm
+}
+
+func numbers(ports []port.Port) []uint16 {
+	ns := make([]uint16, len(ports))
+	for i, p := range ports {
+		ns[i] = p.Number()
+	}
+	return ns
+}
+
+func next(t *testing.T, pm *PortMan, pool string, n int) []uint16 {
+	t.Helper()
+	ports, err := pm.NextGroup(pool, "owner", n)
+	if err != nil {
+		t.Fatalf("NextGroup(%q, %d): %v", pool, n, err)
+	}
+	return numbers(ports)
+}
+
+func TestNewPortManErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		params PortManParams
+		want   error
+	}{
+		{"zero from", PortManParams{Ranges: []Range{{0, 10}}}, ErrorInvalidRange},
+		{"reversed", PortManParams{Ranges: []Range{{20, 10}}}, ErrorInvalidRange},
+		{"overlap in shared", PortManParams{Ranges: []Range{{10, 20}, {20, 30}}}, ErrorPortOverlap},
+		{
+			"overlap with pool",
+			PortManParams{
+				Ranges: []Range{{10, 20}},
+				Pools:  map[string][]Range{"a": {{15, 16}}},
+			},
+			ErrorPortOverlap,
+		},
+		{"all excluded", PortManParams{Ranges: []Range{{10, 11}}, Exclude: []uint16{10, 11}}, ErrorNoPorts},
+		{"empty", PortManParams{}, ErrorNoPorts},
+	}
+	for _, c := range cases {
+		if _, err := NewPortMan(c.params); err != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestNextGroup(t *testing.T) {
+	pm := newPortMan(t, PortManParams{
+		Ranges:  []Range{{100, 103}, {200, 201}},
+		Exclude: []uint16{101},
+	})
+
+	if got, want := next(t, pm, SharedPool, 2), []uint16{100, 102}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first group: got %v, want %v", got, want)
+	}
+	if got, want := next(t, pm, SharedPool, 3), []uint16{103, 200, 201}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second group: got %v, want %v", got, want)
+	}
+	if _, err := pm.NextGroup(SharedPool, "owner", 1); err != ErrorNotEnoughPorts {
+		t.Errorf("exhausted: got %v, want %v", err, ErrorNotEnoughPorts)
+	}
+
+	info, _ := pm.Info()
+	if info.Total != 5 || info.InUse != 5 || info.CurrentCapacity != 0 || info.FailedAllocations != 1 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+}
+
+func TestNextGroupTakesNoneWhenShort(t *testing.T) {
+	pm := newPortMan(t, PortManParams{Ranges: []Range{{100, 102}}})
+
+	if _, err := pm.NextGroup(SharedPool, "owner", 4); err != ErrorNotEnoughPorts {
+		t.Fatalf("got %v, want %v", err, ErrorNotEnoughPorts)
+	}
+	// order is kept for the next allocation
+	if got, want := next(t, pm, SharedPool, 3), []uint16{100, 101, 102}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPools(t *testing.T) {
+	pm := newPortMan(t, PortManParams{
+		Ranges: []Range{{100, 101}},
+		Pools:  map[string][]Range{"a": {{300, 301}}},
+	})
+
+	if got, want := next(t, pm, "a", 2), []uint16{300, 301}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pool a: got %v, want %v", got, want)
+	}
+	// the shared pool is not used for a dedicated pool
+	if _, err := pm.NextGroup("a", "owner", 1); err != ErrorNotEnoughPorts {
+		t.Errorf("exhausted pool: got %v, want %v", err, ErrorNotEnoughPorts)
+	}
+	if _, err := pm.NextGroup("b", "owner", 1); err != ErrorPoolNotFound {
+		t.Errorf("unknown pool: got %v, want %v", err, ErrorPoolNotFound)
+	}
+	if got, want := next(t, pm, SharedPool, 1), []uint16{100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("shared: got %v, want %v", got, want)
+	}
+
+	// returned port goes back to its own pool
+	if err := pm.Return(port.NewPort(301)); err != nil {
+		t.Fatalf("Return: %v", err)
+	}
+	if got, want := next(t, pm, "a", 1), []uint16{301}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pool a after return: got %v, want %v", got, want)
+	}
+}
+
+func TestReturn(t *testing.T) {
+	pm := newPortMan(t, PortManParams{Ranges: []Range{{100, 102}}})
+	next(t, pm, SharedPool, 2)
+
+	if err := pm.Return(port.NewPort(100)); err != nil {
+		t.Fatalf("Return: %v", err)
+	}
+	// returned ports are handed out after ones never used
+	if got, want := next(t, pm, SharedPool, 2), []uint16{102, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := pm.Return(port.NewPort(101)); err != nil {
+		t.Fatalf("Return: %v", err)
+	}
+	if err := pm.Return(port.NewPort(101)); err != ErrorPortNotUsed {
+		t.Errorf("twice: got %v, want %v", err, ErrorPortNotUsed)
+	}
+	if err := pm.Return(port.NilPort()); err != ErrorInvalidPortZero {
+		t.Errorf("zero: got %v, want %v", err, ErrorInvalidPortZero)
+	}
+	// the rest are returned even when one fails
+	if err := pm.Return(port.NewPort(101), port.NewPort(102)); err != ErrorPortNotUsed {
+		t.Errorf("mixed: got %v, want %v", err, ErrorPortNotUsed)
+	}
+	if got, want := next(t, pm, SharedPool, 2), []uint16{101, 102}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after mixed return: got %v, want %v", got, want)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	cooldown := time.Millisecond * 50
+	pm := newPortMan(t, PortManParams{
+		Ranges:           []Range{{100, 101}},
+		CooldownDuration: cooldown,
+	})
+	next(t, pm, SharedPool, 2)
+
+	if err := pm.Return(port.NewPort(100)); err != nil {
+		t.Fatalf("Return: %v", err)
+	}
+	if _, err := pm.NextGroup(SharedPool, "next", 1); err != ErrorNotEnoughPorts {
+		t.Errorf("while cooling: got %v, want %v", err, ErrorNotEnoughPorts)
+	}
+
+	list, _ := pm.Allocations(StatusCooling)
+	if len(list) != 1 || list[0].Number != 100 || list[0].Owner != "owner" {
+		t.Errorf("cooling allocations: %+v", list)
+	}
+
+	time.Sleep(cooldown)
+	if got, want := next(t, pm, SharedPool, 1), []uint16{100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after cooldown: got %v, want %v", got, want)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	pm := newPortMan(t, PortManParams{Ranges: []Range{{100, 103}}})
+
+	if err := pm.Reserve("adopted", port.NewPort(101), port.NewPort(102)); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	// reserved ports are skipped
+	if got, want := next(t, pm, SharedPool, 2), []uint16{100, 103}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	list, _ := pm.Allocations(StatusUsed)
+	if len(list) != 4 || list[1].Owner != "adopted" || list[2].Owner != "adopted" {
+		t.Errorf("used allocations: %+v", list)
+	}
+
+	if err := pm.Return(port.NewPort(101)); err != nil {
+		t.Fatalf("Return: %v", err)
+	}
+	if got, want := next(t, pm, SharedPool, 1), []uint16{101}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after return: got %v, want %v", got, want)
+	}
+}
+
+func TestReserveErrors(t *testing.T) {
+	pm := newPortMan(t, PortManParams{Ranges: []Range{{100, 102}}})
+	next(t, pm, SharedPool, 1)
+
+	cases := []struct {
+		name  string
+		ports []port.Port
+		want  error
+	}{
+		{"zero", []port.Port{port.NilPort()}, ErrorInvalidPortZero},
+		{"not managed", []port.Port{port.NewPort(999)}, ErrorPortNotManaged},
+		{"in use", []port.Port{port.NewPort(101), port.NewPort(100)}, ErrorPortInUse},
+	}
+	for _, c := range cases {
+		if err := pm.Reserve("adopted", c.ports...); err != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	// none is reserved when one fails
+	if got, want := next(t, pm, SharedPool, 2), []uint16{101, 102}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReserveCooling(t *testing.T) {
+	pm := newPortMan(t, PortManParams{
+		Ranges:           []Range{{100, 101}},
+		CooldownDuration: time.Hour,
+	})
+	next(t, pm, SharedPool, 1)
+	if err := pm.Return(port.NewPort(100)); err != nil {
+		t.Fatalf("Return: %v", err)
+	}
+
+	// a process adopted after restart may hold a cooling port
+	if err := pm.Reserve("adopted", port.NewPort(100)); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	if list, _ := pm.Allocations(StatusCooling); len(list) != 0 {
+		t.Errorf("still cooling: %+v", list)
+	}
+}
+
+func TestProbeQuarantine(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("listen: %v", err)
+	}
+	defer held.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("listen: %v", err)
+	}
+	freeNumber := uint16(free.Addr().(*net.TCPAddr).Port)
+	free.Close()
+	heldNumber := uint16(held.Addr().(*net.TCPAddr).Port)
+
+	pm := newPortMan(t, PortManParams{
+		Ranges:             []Range{{heldNumber, heldNumber}, {freeNumber, freeNumber}},
+		ProbeTCP:           true,
+		ProbeAddress:       "127.0.0.1",
+		QuarantineDuration: time.Hour,
+	})
+
+	if got, want := next(t, pm, SharedPool, 1), []uint16{freeNumber}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	info, _ := pm.Info()
+	if !reflect.DeepEqual(info.Quarantined, []uint16{heldNumber}) {
+		t.Errorf("quarantined: got %v, want %v", info.Quarantined, []uint16{heldNumber})
+	}
+	list, _ := pm.Allocations(StatusQuarantined)
+	if len(list) != 1 || list[0].ProbeFailures != 1 {
+		t.Errorf("quarantined allocations: %+v", list)
+	}
+}
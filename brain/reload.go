@@ -0,0 +1,72 @@
+package brain
+
+import (
+	"lift/brain/portman"
+	"lift/setting"
+	"reflect"
+)
+
+type ReloadResult struct {
+	// changed params which need restart to be applied
+	Ignored []string
+}
+
+// Reload applies params which are safe to change live.
+// executables are addressed by name, so they can be reordered or removed.
+// running processes keep definitions they were launched with until they exit.
+func (b *Brain) Reload(params *BrainParams) (*ReloadResult, error) {
+	current := b.Params()
+
+	next := *params
+	result := &ReloadResult{
+		Ignored: []string{},
+	}
+	if !reflect.DeepEqual(next.PortParams, current.PortParams) {
+		next.PortParams = current.PortParams
+		result.Ignored = append(result.Ignored, "PortParams")
+	}
+	// pools are made on start, changed ranges would launch in the old pool
+	for _, exe := range next.GSExecutables {
+		if poolChanged(exe, next.PortParams.Pools) {
+			result.Ignored = append(result.Ignored, "GSExecutables["+exe.Name+"].PortRanges")
+		}
+	}
+	if next.CallbackURL != current.CallbackURL {
+		next.CallbackURL = current.CallbackURL
+		result.Ignored = append(result.Ignored, "CallbackURL")
+	}
+	if next.CgroupParent != current.CgroupParent {
+		next.CgroupParent = current.CgroupParent
+		result.Ignored = append(result.Ignored, "CgroupParent")
+	}
+	if next.JournalFile != current.JournalFile {
+		next.JournalFile = current.JournalFile
+		result.Ignored = append(result.Ignored, "JournalFile")
+	}
+
+	b.params.Store(&next)
+	if next.LoopInterval != current.LoopInterval {
+		b.ticker.Reset(next.LoopInterval)
+	}
+
+	b.logger.Infof(
+		"brain reloaded, executables: %d, ignored: %v",
+		len(next.GSExecutables), result.Ignored,
+	)
+	return result, nil
+}
+
+// poolChanged tells dedicated ranges of the executable differ from its pool.
+// executables without a pool, including renamed ones, use the shared pool.
+func poolChanged(exe setting.GSExecutable, pools map[string][]portman.Range) bool {
+	pool := pools[exe.Name]
+	if len(pool) != len(exe.PortRanges) {
+		return true
+	}
+	for i, r := range exe.PortRanges {
+		if r.From != pool[i].From || r.To != pool[i].To {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package brain
+
+import (
+	"errors"
+	"lift/gsmap/gs"
+	"lift/gsmap/gsinfo"
+	"lift/procstat"
+	"lift/setting"
+	"time"
+)
+
+const (
+	MB = 1024 * 1024
+)
+
+var (
+	ErrorInsufficientResources = errors.New("host has no headroom for the process")
+)
+
+const (
+	RejectResources = "resources"
+)
+
+// per-executable limits of processes
+const (
+	LimitRSS     = "rss"
+	LimitCPU     = "cpu"
+	LimitFDs     = "fds"
+	LimitThreads = "threads"
+)
+
+const (
+	// consecutive samples over MaxCPU to shut a process down,
+	// so that bursts like loading a level are tolerated
+	CPULimitSamples = 3
+)
+
+type cpuStreak struct {
+	timeSample time.Time
+	count      int
+}
+
+type ChildUsage struct {
+	Id         string
+	Executable string
+	Pid        int
+	CPUUsage   float64
+	RSS        int64
+}
+
+// Resources is the latest sample of host and processes lift launched.
+type Resources struct {
+	Host     *procstat.HostStat
+	Children []ChildUsage
+
+	ChildrenCPUUsage float64
+	ChildrenRSS      int64
+
+	MaxCPUPercent    float64
+	MaxMemoryPercent float64
+}
+
+// sampleResources samples host and children at ResourceSampleInterval
+// until the brain is closed.
+func (b *Brain) sampleResources() {
+	var host *procstat.HostStat
+	// the interval may change by reload
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-b.closeCh:
+			return
+		case <-timer.C:
+		}
+
+		interval := b.Params().ResourceSampleInterval
+		if interval <= 0 {
+			b.resources.Store(nil)
+			timer.Reset(time.Second)
+			continue
+		}
+
+		h, err := procstat.ReadHost(host)
+		if err != nil {
+			b.logger.Warnf("%s: failed to sample host", err.Error())
+			timer.Reset(interval)
+			continue
+		}
+		host = h
+
+		r := &Resources{
+			Host:     h,
+			Children: make([]ChildUsage, 0),
+		}
+		b.gsMap.Range(func(id string, gs *gs.GS) bool {
+			// processes sample themselves
+			p := gs.ProcessStat()
+			if p == nil {
+				return true
+			}
+
+			r.Children = append(r.Children, ChildUsage{
+				Id:         id,
+				Executable: gs.Executable(),
+				Pid:        p.Pid,
+				CPUUsage:   p.CPUUsage,
+				RSS:        p.RSS,
+			})
+			r.ChildrenCPUUsage += p.CPUUsage
+			r.ChildrenRSS += p.RSS
+			return true
+		})
+		b.resources.Store(r)
+		timer.Reset(interval)
+	}
+}
+
+// Resources returns the latest sample with current headroom setting,
+// nil means sampling is disabled or not done yet.
+func (b *Brain) Resources() *Resources {
+	r := b.resources.Load()
+	if r == nil {
+		return nil
+	}
+
+	params := b.Params()
+	copied := *r
+	copied.MaxCPUPercent = params.HostMaxCPUPercent
+	copied.MaxMemoryPercent = params.HostMaxMemoryPercent
+	return &copied
+}
+
+// exceededLimit returns which per-executable limit the process exceeds,
+// empty string means within limits or not sampled yet.
+// cpu is exceeded after CPULimitSamples consecutive samples over MaxCPU.
+func (b *Brain) exceededLimit(info *gsinfo.GSInfo) string {
+	p := info.Process
+	if p == nil {
+		return ""
+	}
+	_, exe, err := b.Executable(info.Executable)
+	if err != nil {
+		// removed by reload, its processes keep running
+		return ""
+	}
+
+	switch {
+	case exe.MaxRSSMB > 0 && p.RSS > exe.MaxRSSMB*MB:
+		return LimitRSS
+	case exe.MaxFDs > 0 && p.FDs > exe.MaxFDs:
+		return LimitFDs
+	case exe.MaxThreads > 0 && p.Threads > exe.MaxThreads:
+		return LimitThreads
+	case b.overCPU(info.Id, p, exe.MaxCPU):
+		return LimitCPU
+	}
+	return ""
+}
+
+// overCPU counts each new sample over max and resets on one within it.
+func (b *Brain) overCPU(id string, p *procstat.ProcessStat, max float64) bool {
+	if max <= 0 || p.CPUUsage <= max {
+		b.cpuOver.Delete(id)
+		return false
+	}
+
+	v, _ := b.cpuOver.LoadOrStore(id, &cpuStreak{})
+	s := v.(*cpuStreak)
+	if !p.Time.Equal(s.timeSample) {
+		s.timeSample = p.Time
+		s.count++
+	}
+	return s.count >= CPULimitSamples
+}
+
+// admitResources projects usage of the host with expected cost of
+// the executable and starting processes which are not loaded yet.
+// zero headroom percent disables the check.
+func (b *Brain) admitResources(exe setting.GSExecutable, c *processCount) error {
+	params := b.Params()
+	r := b.resources.Load()
+	if r == nil ||
+		(params.HostMaxCPUPercent <= 0 && params.HostMaxMemoryPercent <= 0) {
+		return nil
+	}
+
+	cpu := exe.ExpectedCPU
+	mem := exe.ExpectedMemoryMB * MB
+	for _, e := range params.GSExecutables {
+		starting := float64(c.startingByExe[e.Name])
+		cpu += e.ExpectedCPU * starting
+		mem += int64(float64(e.ExpectedMemoryMB*MB) * starting)
+	}
+
+	h := r.Host
+	if params.HostMaxCPUPercent > 0 {
+		used := h.CPUUsage * float64(h.NumCPU)
+		limit := float64(h.NumCPU) * params.HostMaxCPUPercent / 100
+		if used+cpu > limit {
+			return ErrorInsufficientResources
+		}
+	}
+	if params.HostMaxMemoryPercent > 0 {
+		used := h.MemTotal - h.MemAvailable
+		limit := int64(float64(h.MemTotal) * params.HostMaxMemoryPercent / 100)
+		if used+mem > limit {
+			return ErrorInsufficientResources
+		}
+	}
+	return nil
+}
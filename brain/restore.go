@@ -0,0 +1,172 @@
+package brain
+
+import (
+	"lift/brain/journal"
+	"lift/brain/portman/port"
+	"lift/cgroup"
+	"lift/gsmap/gs"
+	"lift/gsmap/gslog"
+	"lift/gsmap/gsprocess"
+	"lift/setting"
+	"strconv"
+
+	libuuid "github.com/google/uuid"
+)
+
+// restore adopts processes recorded in journal by previous lift.
+// adopted processes have RestoreGrace to connect again.
+func (b *Brain) restore() {
+	for _, e := range b.journal.Entries() {
+		if err := b.restoreEntry(e); err != nil {
+			b.logger.Warnf(
+				"%s: process id: %s pid: %d was not restored",
+				err.Error(), e.Uuid, e.Pid,
+			)
+			b.discard(e)
+			b.forget(e.Uuid)
+			continue
+		}
+
+		b.logger.Infof(
+			"process id: %s pid: %d restored, port: %d",
+			e.Uuid, e.Pid, e.Port,
+		)
+	}
+}
+
+// openCgroup finds the cgroup previous lift created for the process.
+func (b *Brain) openCgroup(id string) *cgroup.Cgroup {
+	parent := b.Params().CgroupParent
+	if parent == "" {
+		return nil
+	}
+	return cgroup.Open(parent, id)
+}
+
+func (b *Brain) restoreEntry(e journal.Entry) error {
+	// entries written before executables had names are addressed by index
+	key := e.Executable
+	if key == "" {
+		key = strconv.Itoa(e.Index)
+	}
+	idx, exe, err := b.Executable(key)
+	if err != nil {
+		return err
+	}
+
+	uuid, err := libuuid.Parse(e.Uuid)
+	if err != nil {
+		return err
+	}
+
+	ports := restorePorts(e)
+	ps := make([]port.Port, len(ports))
+	for i, np := range ports {
+		ps[i] = np.Port
+	}
+
+	param := b.newGSParams(idx, exe, uuid, ports, e.Secret)
+	cg := b.openCgroup(e.Uuid)
+	output, err := gslog.NewDetached(b.Params().GSLog, exe.Name, e.Uuid)
+	if err != nil {
+		return err
+	}
+	gs, err := gs.RestoreGS(
+		param,
+		e.Pid,
+		e.TimeStarted,
+		e.Allocated,
+		cg,
+		output,
+		b.metrics,
+		b.logger,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err = b.portMan.Reserve(e.Uuid, ps...); err != nil {
+		return err
+	}
+
+	id := param.UuidString()
+	if err = gs.StartProcess(b.onGSClosed(id, ps)); err != nil {
+		b.portMan.Return(ps...)
+		return err
+	}
+
+	b.gsMap.Add(id, gs)
+	return nil
+}
+
+// discard kills the process of an entry which could not be restored,
+// so that it does not keep running untracked, and removes its cgroup.
+// cgroup is also left by a process which exited while lift was down.
+func (b *Brain) discard(e journal.Entry) {
+	if err := gsprocess.KillOrphan(e.Pid, e.Uuid, e.Secret); err != nil {
+		b.logger.Warnf("%s: failed to kill process id: %s pid: %d", err.Error(), e.Uuid, e.Pid)
+	}
+	removeCgroup(b.openCgroup(e.Uuid))
+}
+
+// restorePorts returns ports in the order they were launched with.
+func restorePorts(e journal.Entry) []port.NamedPort {
+	if len(e.Ports) == 0 {
+		return []port.NamedPort{{
+			Name: setting.DefaultPortName,
+			Port: port.NewPort(e.Port),
+		}}
+	}
+
+	ports := make([]port.NamedPort, len(e.Ports))
+	for i, p := range e.Ports {
+		ports[i] = port.NamedPort{Name: p.Name, Port: port.NewPort(p.Number)}
+	}
+	return ports
+}
+
+func (b *Brain) record(id string, gs *gs.GS) {
+	if b.journal == nil {
+		return
+	}
+
+	info := gs.Info()
+	ports := make([]journal.Port, 0, len(gs.Ports()))
+	for _, np := range gs.Ports() {
+		ports = append(ports, journal.Port{Name: np.Name, Number: np.Port.Number()})
+	}
+	if err := b.journal.Put(journal.Entry{
+		Pid:         gs.Pid(),
+		Uuid:        id,
+		Index:       info.Index,
+		Executable:  info.Executable,
+		ProcessName: gs.ProcessName(),
+		Port:        info.Port,
+		Ports:       ports,
+		Secret:      gs.Secret(),
+		TimeStarted: info.Summary.TimeStarted,
+		Allocated:   info.Allocated,
+	}); err != nil {
+		b.logger.Warnf("%s: failed to record process id: %s to journal", err.Error(), id)
+	}
+}
+
+func (b *Brain) recordAllocated(id string) {
+	if b.journal == nil {
+		return
+	}
+
+	if err := b.journal.SetAllocated(id); err != nil {
+		b.logger.Warnf("%s: failed to record allocation of process id: %s to journal", err.Error(), id)
+	}
+}
+
+func (b *Brain) forget(id string) {
+	if b.journal == nil {
+		return
+	}
+
+	if err := b.journal.Remove(id); err != nil {
+		b.logger.Warnf("%s: failed to remove process id: %s from journal", err.Error(), id)
+	}
+}
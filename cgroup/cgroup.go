@@ -0,0 +1,235 @@
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// period of cpu.max in microseconds
+	CPUPeriod = 100000
+	// killed processes are waited up to this to remove the cgroup
+	KillWait = time.Second
+)
+
+var (
+	ErrorNotCgroup2       = errors.New("not a cgroup v2 directory")
+	ErrorNoController     = errors.New("controller is not available in parent cgroup")
+	ErrorUnexpectedFormat = errors.New("unexpected format in cgroup file")
+)
+
+// Limits of a process, zero means unlimited.
+type Limits struct {
+	// cores
+	CPU      float64
+	MemoryMB int64
+	Pids     int64
+}
+
+func (l Limits) Empty() bool {
+	return l.CPU <= 0 && l.MemoryMB <= 0 && l.Pids <= 0
+}
+
+// controllers returns controllers the limits need.
+func (l Limits) controllers() []string {
+	c := make([]string, 0, 3)
+	if l.CPU > 0 {
+		c = append(c, "cpu")
+	}
+	if l.MemoryMB > 0 {
+		c = append(c, "memory")
+	}
+	if l.Pids > 0 {
+		c = append(c, "pids")
+	}
+	return c
+}
+
+// Cgroup is a cgroup v2 directory of a single process.
+type Cgroup struct {
+	path string
+}
+
+// Check makes sure parent is a cgroup v2 directory lift can create children in.
+func Check(parent string) error {
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		if os.IsNotExist(err) {
+			return ErrorNotCgroup2
+		}
+		return err
+	}
+	return nil
+}
+
+// New creates the cgroup named name under delegated parent
+// and writes the limits, controllers are enabled in parent as needed.
+func New(parent string, name string, l Limits) (*Cgroup, error) {
+	if err := enable(parent, l.controllers()); err != nil {
+		return nil, err
+	}
+
+	c := &Cgroup{path: filepath.Join(parent, name)}
+	if err := os.Mkdir(c.path, 0755); err != nil {
+		return nil, err
+	}
+
+	if l.CPU > 0 {
+		quota := int64(l.CPU * CPUPeriod)
+		if err := c.write("cpu.max", strconv.FormatInt(quota, 10)+" "+strconv.Itoa(CPUPeriod)); err != nil {
+			c.Remove()
+			return nil, err
+		}
+	}
+	if l.MemoryMB > 0 {
+		if err := c.write("memory.max", strconv.FormatInt(l.MemoryMB*1024*1024, 10)); err != nil {
+			c.Remove()
+			return nil, err
+		}
+	}
+	if l.Pids > 0 {
+		if err := c.write("pids.max", strconv.FormatInt(l.Pids, 10)); err != nil {
+			c.Remove()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Open returns the existing cgroup named name under parent,
+// nil when it does not exist.
+func Open(parent string, name string) *Cgroup {
+	path := filepath.Join(parent, name)
+	if _, err := os.Stat(filepath.Join(path, "cgroup.procs")); err != nil {
+		return nil
+	}
+	return &Cgroup{path: path}
+}
+
+func enable(parent string, controllers []string) error {
+	b, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrorNotCgroup2
+		}
+		return err
+	}
+	available := strings.Fields(string(b))
+
+	b, err = os.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+	enabled := strings.Fields(string(b))
+
+	add := make([]string, 0, len(controllers))
+	for _, c := range controllers {
+		if !contains(available, c) {
+			return fmt.Errorf("%w: %s", ErrorNoController, c)
+		}
+		if !contains(enabled, c) {
+			add = append(add, "+"+c)
+		}
+	}
+	if len(add) == 0 {
+		return nil
+	}
+	return os.WriteFile(
+		filepath.Join(parent, "cgroup.subtree_control"),
+		[]byte(strings.Join(add, " ")),
+		0,
+	)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Cgroup) Path() string {
+	return c.path
+}
+
+func (c *Cgroup) write(file string, value string) error {
+	return os.WriteFile(filepath.Join(c.path, file), []byte(value), 0)
+}
+
+// Dir opens the directory of the cgroup, a process cloned with it as
+// SysProcAttr.CgroupFD starts in the cgroup without running outside of it.
+func (c *Cgroup) Dir() (*os.File, error) {
+	return os.Open(c.path)
+}
+
+// OOMKills returns how many processes in the cgroup were killed by oom killer.
+func (c *Cgroup) OOMKills() (int64, error) {
+	f, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		// memory controller is not enabled for the cgroup
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 || fields[0] != "oom_kill" {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, ErrorUnexpectedFormat
+		}
+		return n, nil
+	}
+	return 0, s.Err()
+}
+
+// Kill kills all processes in the cgroup, children which left the process
+// group of the gs included, and waits up to KillWait for them to exit.
+// kernels before 5.14 do not have cgroup.kill, then nothing is done.
+func (c *Cgroup) Kill() error {
+	f, err := os.OpenFile(filepath.Join(c.path, "cgroup.kill"), os.O_WRONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	_, err = f.WriteString("1")
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(KillWait)
+	for c.populated() && time.Now().Before(deadline) {
+		time.Sleep(KillWait / 20)
+	}
+	return nil
+}
+
+// populated tells whether processes remain in the cgroup.
+func (c *Cgroup) populated() bool {
+	b, err := os.ReadFile(filepath.Join(c.path, "cgroup.events"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(b), "populated 1")
+}
+
+// Remove removes the cgroup, which fails while processes remain in it.
+func (c *Cgroup) Remove() error {
+	return os.Remove(c.path)
+}
@@ -7,6 +7,10 @@ import (
 	"lift/gsmap/monitor"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	libuuid "github.com/google/uuid"
@@ -14,9 +18,14 @@ import (
 )
 
 type DummyParams struct {
-	Uuid    string
-	Address string
-	Port    string
+	Uuid         string
+	Secret       string
+	CallbackURL  string
+	Address      string
+	Port         string
+	MatchSec     int
+	ReconnectSec int
+	Started      time.Time
 }
 
 func (p *DummyParams) RawUuid() libuuid.UUID {
@@ -25,49 +34,136 @@ func (p *DummyParams) RawUuid() libuuid.UUID {
 
 type DummyConnectionHandle struct {
 	conn *websocket.Conn
+	mu   sync.Mutex
 }
 
-func (h *DummyConnectionHandle) SendMonitoringMessage(param *DummyParams) {
-	ticker := time.Tick(time.Second)
+func (h *DummyConnectionHandle) ReadJSON(v interface{}) error {
+	return h.conn.ReadJSON(v)
+}
+
+func (h *DummyConnectionHandle) WriteJSON(v interface{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.conn.WriteJSON(v)
+}
+
+func (h *DummyConnectionHandle) SendMonitoringMessage(param *DummyParams) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	rawUuid := param.RawUuid()
 
-	for range ticker {
+	for range ticker.C {
 		count := rand.Int63n(2)
 		count++
-		msg := monitor.MonitoringMessage{
-			GuidRaw:            rawUuid[:],
-			ConnectionCount:    count,
-			SessionCount:       count,
-			ActiveSessionCount: count,
-			ErrorCode:          monitor.NoError,
-			ErrorUtf8:          nil,
+		// match is over, no one is connected anymore
+		if param.MatchSec > 0 &&
+			time.Since(param.Started) >= time.Second*time.Duration(param.MatchSec) {
+			count = 0
 		}
-		if err := h.conn.WriteJSON(&msg); err != nil {
-			panic(err)
+		if err := monitor.SendMonitoring(h, rawUuid[:], count, count, count); err != nil {
+			return err
 		}
 		// fmt.Println("sent a monitoring message")
 	}
+	return nil
 }
 
-func serverURL(uuid string) string {
-	return fmt.Sprintf("ws://127.0.0.1:9990/process/connect/%s", uuid)
+func (h *DummyConnectionHandle) ListenCommand(param *DummyParams) error {
+	rawUuid := param.RawUuid()
+
+	for {
+		cmd, err := monitor.ReadCommand(h)
+		if err != nil {
+			return err
+		}
+
+		var replyCode uint8 = monitor.ReplyOk
+		var replyPayload interface{}
+		switch cmd.CommandCode {
+		case monitor.CommandShutdown:
+			fmt.Println("shutdown requested, finishing the match")
+			time.Sleep(time.Second)
+			if err := monitor.SendShutdownAck(h, rawUuid[:]); err != nil {
+				return err
+			}
+			h.conn.Close()
+			fmt.Println("shutdown acknowledged, exiting")
+			os.Exit(0)
+		case monitor.CommandReserveSlot:
+			p := monitor.ReserveSlotPayload{}
+			if err := cmd.DecodePayload(&p); err != nil {
+				replyCode = monitor.ReplyRejected
+				break
+			}
+			fmt.Printf("reserved slots for %v\n", p.PlayerIds)
+		case monitor.CommandSetMaxPlayers:
+			p := monitor.SetMaxPlayersPayload{}
+			if err := cmd.DecodePayload(&p); err != nil || p.MaxPlayers <= 0 {
+				replyCode = monitor.ReplyRejected
+				break
+			}
+			fmt.Printf("max players set to %d\n", p.MaxPlayers)
+		case monitor.CommandBroadcastNotice:
+			p := monitor.BroadcastNoticePayload{}
+			if err := cmd.DecodePayload(&p); err != nil {
+				replyCode = monitor.ReplyRejected
+				break
+			}
+			fmt.Printf("notice: %s\n", p.Message)
+		case monitor.CommandDrain:
+			fmt.Println("draining, no new players are accepted")
+		case monitor.CommandCustom:
+			// echo back for testing
+			replyPayload = cmd.Payload
+		default:
+			replyCode = monitor.ReplyUnsupported
+		}
+
+		if err := monitor.SendReply(h, rawUuid[:], cmd, replyCode, replyPayload); err != nil {
+			return err
+		}
+	}
+}
+
+// Run blocks until the connection is broken.
+func (h *DummyConnectionHandle) Run(param *DummyParams) error {
+	defer h.conn.Close()
+
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- h.ListenCommand(param)
+	}()
+	go func() {
+		errCh <- h.SendMonitoringMessage(param)
+	}()
+	return <-errCh
 }
 
 func parseFlags() *DummyParams {
 	address := flag.String("a", "127.0.0.1", "listening address")
 	port := flag.String("p", "7777", "listening port")
 	uuid := flag.String("u", "00000000-0000-0000-0000-000000000000", "client uuid")
+	matchSec := flag.Int("m", 0, "seconds until connections drop to zero, 0 means forever")
+	reconnectSec := flag.Int("r", 60, "seconds to keep trying to reconnect to lift")
+	callbackURL := flag.String("c", os.Getenv(monitor.EnvCallbackURL), "url to connect to lift")
 
 	flag.Parse()
 	return &DummyParams{
-		Uuid:    *uuid,
-		Address: *address,
-		Port:    *port,
+		Uuid:         *uuid,
+		Secret:       os.Getenv(monitor.EnvSecret),
+		CallbackURL:  *callbackURL,
+		Address:      *address,
+		Port:         *port,
+		MatchSec:     *matchSec,
+		ReconnectSec: *reconnectSec,
+		Started:      time.Now(),
 	}
 }
 
 func connect(param *DummyParams) (*DummyConnectionHandle, error) {
-	conn, res, err := websocket.DefaultDialer.Dial(serverURL(param.Uuid), nil)
+	header := http.Header{}
+	header.Set(monitor.HeaderSecret, param.Secret)
+	conn, res, err := websocket.DefaultDialer.Dial(param.CallbackURL, header)
 	if err != nil {
 		return nil, err
 	}
@@ -80,19 +176,47 @@ func connect(param *DummyParams) (*DummyConnectionHandle, error) {
 	}, nil
 }
 
+// connectWithRetry keeps trying while lift is restarting.
+func connectWithRetry(param *DummyParams) (*DummyConnectionHandle, error) {
+	deadline := time.Now().Add(time.Second * time.Duration(param.ReconnectSec))
+	for {
+		handle, err := connect(param)
+		if err == nil {
+			return handle, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
 func main() {
 	params := parseFlags()
+	if params.CallbackURL == "" {
+		panic("no callback url, lift passes it with " + monitor.EnvCallbackURL)
+	}
 	fmt.Printf("dummy for testing is starting at %s:%s as [%s]\n",
 		params.Address,
 		params.Port,
 		params.Uuid,
 	)
 
+	// stdout is a pipe to lift, keep running even if lift is gone
+	signal.Ignore(syscall.SIGPIPE)
+
 	handle, err := connect(params)
 	if err != nil {
 		panic(err)
 	}
-	defer handle.conn.Close()
 
-	handle.SendMonitoringMessage(params)
+	for {
+		err = handle.Run(params)
+		fmt.Printf("connection lost: %s, reconnecting\n", err.Error())
+
+		handle, err = connectWithRetry(params)
+		if err != nil {
+			panic(err)
+		}
+	}
 }
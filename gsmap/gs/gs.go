@@ -2,15 +2,25 @@ package gs
 
 import (
 	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"lift/brain/portman/port"
+	"lift/cgroup"
 	"lift/gsmap/gsinfo"
+	"lift/gsmap/gslog"
 	"lift/gsmap/gsparams"
 	"lift/gsmap/gsprocess"
+	"lift/gsmap/gsstate"
 	"lift/gsmap/monitor"
 	"lift/logger"
+	"lift/metrics"
+	"lift/procstat"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	libuuid "github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -18,47 +28,129 @@ type GS struct {
 	params  *gsparams.GSParams
 	process *gsprocess.GSProcess
 	conn    *websocket.Conn
+	writeMu sync.Mutex
+	metrics *metrics.LiftMetrics
 	logger  logger.Logger
 
 	timeStarted         *time.Time
 	timeEstablished     *atomic.Pointer[time.Time]
+	timeAllocated       *atomic.Pointer[time.Time]
 	timeLastCommunicate *atomic.Pointer[time.Time]
 
 	lastConnectionCount    *atomic.Int64
 	lastSessionCount       *atomic.Int64
 	lastActiveSessionCount *atomic.Int64
 
-	fatal *atomic.Bool
+	state       *gsstate.StateMachine
+	allocated   *atomic.Bool
+	connClaimed *atomic.Bool
+	restored    bool
+
+	ending        *atomic.Bool
+	killed        *atomic.Bool
+	shutdownAckCh chan bool
+	exitedCh      chan bool
+	pendingReply  *sync.Map
 
 	onGSClosed  func() error
 	closingWait sync.WaitGroup
 	closeCh     chan bool
 }
 
-func NewGS(params *gsparams.GSParams, logger logger.Logger) (*GS, error) {
-	process, err := gsprocess.NewGSProcess(params, logger)
+var (
+	ErrorNotEstablished = errors.New("gs is not established")
+	ErrorCommandTimeout = errors.New("command reply timed out")
+	ErrorProcessExited  = errors.New("gs process exited")
+)
+
+// reasons of transitions to Failed
+const (
+	ReasonConnectionBroken = "monitoring connection broken"
+	ReasonBrokenUuid       = "received broken uuid"
+	// followed by the message gs reported
+	ReasonFatalReported = "fatal reported: "
+)
+
+func NewGS(
+	params *gsparams.GSParams,
+	cg *cgroup.Cgroup,
+	output *gslog.Writer,
+	metrics *metrics.LiftMetrics,
+	logger logger.Logger,
+) (*GS, error) {
+	process, err := gsprocess.NewGSProcess(params, cg, output, logger)
 	if err != nil {
 		return nil, err
 	}
 
+	return newGS(params, process, "launched", metrics, logger), nil
+}
+
+// RestoreGS wraps a process launched by previous lift.
+// the gs stays in Starting until the process connects again.
+func RestoreGS(
+	params *gsparams.GSParams,
+	pid int,
+	timeStarted time.Time,
+	allocated bool,
+	cg *cgroup.Cgroup,
+	output *gslog.Writer,
+	metrics *metrics.LiftMetrics,
+	logger logger.Logger,
+) (*GS, error) {
+	process, err := gsprocess.AdoptGSProcess(params, pid, cg, output, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	gs := newGS(params, process, "restored after lift restart", metrics, logger)
+	gs.timeStarted = &timeStarted
+	gs.restored = true
+	if allocated {
+		gs.Allocate()
+	}
+	return gs, nil
+}
+
+func newGS(
+	params *gsparams.GSParams,
+	process *gsprocess.GSProcess,
+	reason string,
+	metrics *metrics.LiftMetrics,
+	logger logger.Logger,
+) *GS {
 	return &GS{
 		params:                 params,
 		process:                process,
+		metrics:                metrics,
 		logger:                 logger,
 		timeStarted:            nil,
 		timeEstablished:        &atomic.Pointer[time.Time]{},
+		timeAllocated:          &atomic.Pointer[time.Time]{},
 		timeLastCommunicate:    &atomic.Pointer[time.Time]{},
 		lastConnectionCount:    &atomic.Int64{},
 		lastSessionCount:       &atomic.Int64{},
 		lastActiveSessionCount: &atomic.Int64{},
-		fatal:                  &atomic.Bool{},
+		state:                  gsstate.NewStateMachine(reason),
+		allocated:              &atomic.Bool{},
+		connClaimed:            &atomic.Bool{},
+		restored:               false,
+		ending:                 &atomic.Bool{},
+		killed:                 &atomic.Bool{},
+		shutdownAckCh:          make(chan bool, 1),
+		exitedCh:               make(chan bool),
+		pendingReply:           &sync.Map{},
 		closingWait:            sync.WaitGroup{},
 		closeCh:                make(chan bool),
-	}, nil
+	}
 }
 
 func (gs *GS) StartProcess(onGSClosed func() error) error {
 	err := gs.process.Start(func() {
+		exit := gs.process.Exit()
+		gs.metrics.Exits.Inc(append(gs.MetricLabels(), exit.Class)...)
+		gs.transit(gsstate.Exited, exit.Reason)
+		close(gs.exitedCh)
 		gs.closeCh <- true
 		gs.closingWait.Done()
 	})
@@ -66,8 +158,10 @@ func (gs *GS) StartProcess(onGSClosed func() error) error {
 		return err
 	}
 
-	now := time.Now()
-	gs.timeStarted = &now
+	if gs.timeStarted == nil {
+		now := time.Now()
+		gs.timeStarted = &now
+	}
 	gs.onGSClosed = onGSClosed
 	gs.closingWait.Add(2)
 	go gs.listen()
@@ -76,22 +170,265 @@ func (gs *GS) StartProcess(onGSClosed func() error) error {
 	return nil
 }
 
-func (gs *GS) EndProcess() {
+// EndProcess closes the process gracefully in background.
+// an established gs is requested to shutdown over websocket first,
+// then SIGTERM is sent after acknowledged or grace period,
+// then SIGKILL is sent after terminate timeout.
+// returns false when the process is ending already.
+func (gs *GS) EndProcess(reason string) bool {
+	if !gs.ending.CompareAndSwap(false, true) {
+		return false
+	}
+
+	go gs.endProcess(reason)
+	return true
+}
+
+func (gs *GS) endProcess(reason string) {
+	// zero grace sends SIGTERM without asking gs
+	if gs.params.ShutdownGrace() > 0 && gs.requestShutdown(reason) {
+		select {
+		case <-gs.exitedCh:
+			return
+		case <-gs.shutdownAckCh:
+			gs.logger.Debug(gs.params.LogWithId("shutdown acknowledged"))
+		case <-time.After(gs.params.ShutdownGrace()):
+			gs.logger.Warn(gs.params.LogWithId("shutdown was not acknowledged in grace period"))
+		}
+	}
+
+	gs.transit(gsstate.ShuttingDown, reason)
+	// zero timeout sends SIGKILL without SIGTERM
+	if gs.params.TerminateTimeout() > 0 {
+		if err := gs.process.Terminate(); err != nil {
+			gs.logger.Debugf(gs.params.LogWithId("%s: failed to send SIGTERM"), err.Error())
+		}
+
+		select {
+		case <-gs.exitedCh:
+			return
+		case <-time.After(gs.params.TerminateTimeout()):
+			gs.logger.Warn(gs.params.LogWithId("process did not exit after SIGTERM, killing"))
+		}
+	}
+
 	gs.process.Close()
 }
 
-func (gs *GS) Info() gsinfo.GSInfo {
-	i := gsinfo.GSInfo{
-		Index: gs.params.Index(),
+func (gs *GS) requestShutdown(reason string) bool {
+	switch gs.state.Current() {
+	case gsstate.Ready, gsstate.Allocated, gsstate.Draining:
+	default:
+		return false
+	}
+
+	if err := gs.write(&monitor.CommandMessage{
+		GuidRaw:     gs.params.UuidRaw(),
+		RequestId:   libuuid.NewString(),
+		CommandCode: monitor.CommandShutdown,
+	}); err != nil {
+		gs.logger.Warnf(gs.params.LogWithId("%s: failed to request shutdown"), err.Error())
+		return false
+	}
+
+	gs.transit(gsstate.Draining, "shutdown requested: "+reason)
+	return true
+}
+
+// SendCommand sends the command to the gs and waits for the reply.
+// a gs which replied ok to drain command transits to Draining.
+func (gs *GS) SendCommand(
+	code uint8,
+	payload json.RawMessage,
+	timeout time.Duration,
+) (*monitor.MonitoringMessage, error) {
+	switch gs.state.Current() {
+	case gsstate.Ready, gsstate.Allocated, gsstate.Draining:
+	default:
+		return nil, ErrorNotEstablished
+	}
+
+	requestId := libuuid.NewString()
+	replyCh := make(chan *monitor.MonitoringMessage, 1)
+	gs.pendingReply.Store(requestId, replyCh)
+	defer gs.pendingReply.Delete(requestId)
+
+	if err := gs.write(&monitor.CommandMessage{
+		GuidRaw:     gs.params.UuidRaw(),
+		RequestId:   requestId,
+		CommandCode: code,
+		Payload:     payload,
+	}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case m := <-replyCh:
+		if code == monitor.CommandDrain && m.ReplyCode == monitor.ReplyOk {
+			gs.transit(gsstate.Draining, "drain requested")
+		}
+		return m, nil
+	case <-gs.exitedCh:
+		return nil, ErrorProcessExited
+	case <-time.After(timeout):
+		return nil, ErrorCommandTimeout
+	}
+}
+
+func (gs *GS) write(v interface{}) error {
+	gs.writeMu.Lock()
+	defer gs.writeMu.Unlock()
+
+	if err := gs.conn.SetWriteDeadline(gs.params.NextMonitoringTimeout()); err != nil {
+		return err
+	}
+	return gs.conn.WriteJSON(v)
+}
+
+func (gs *GS) State() gsstate.State {
+	return gs.state.Current()
+}
+
+func (gs *GS) transit(to gsstate.State, reason string) {
+	if err := gs.state.Transit(to, reason); err != nil {
+		gs.logger.Debugf(gs.params.LogWithId("%s, reason: %s"), err.Error(), reason)
+		return
+	}
+	gs.logger.Infof(gs.params.LogWithId("state changed to %s, reason: %s"), to, reason)
+}
+
+func (gs *GS) Id() string {
+	return gs.params.UuidString()
+}
+
+func (gs *GS) Index() int {
+	return gs.params.Index()
+}
+
+func (gs *GS) Port() port.Port {
+	return gs.params.Port()
+}
+
+func (gs *GS) Ports() []port.NamedPort {
+	return gs.params.Ports()
+}
+
+// GSPort returns ports to hand out to clients.
+func (gs *GS) GSPort() *gsinfo.GSPort {
+	return &gsinfo.GSPort{
 		Id:    gs.params.UuidString(),
 		Port:  gs.params.Port().Number(),
+		Ports: gs.params.PortNumbers(),
+	}
+}
+
+// VerifySecret compares the secret minted for this launch.
+func (gs *GS) VerifySecret(secret string) bool {
+	return subtle.ConstantTimeCompare(
+		[]byte(gs.params.Secret()),
+		[]byte(secret),
+	) == 1
+}
+
+func (gs *GS) Secret() string {
+	return gs.params.Secret()
+}
+
+func (gs *GS) Executable() string {
+	return gs.params.Executable()
+}
+
+func (gs *GS) ProcessName() string {
+	return gs.params.ProcessName()
+}
+
+// MetricLabels returns label values of executable and process name.
+func (gs *GS) MetricLabels() []string {
+	return []string{gs.params.Executable(), gs.params.ProcessName()}
+}
+
+func (gs *GS) Pid() int {
+	return gs.process.Pid()
+}
+
+// Exit returns the record of the exit with runtime, nil while running.
+func (gs *GS) Exit() *gsinfo.ExitInfo {
+	e := gs.process.Exit()
+	if e == nil {
+		return nil
+	}
+
+	copied := *e
+	if gs.timeStarted != nil {
+		copied.TimeStarted = *gs.timeStarted
+		copied.RuntimeSec = e.TimeExited.Sub(*gs.timeStarted).Seconds()
+	}
+	return &copied
+}
+
+// Logs returns recent output lines of the process.
+func (gs *GS) Logs() []gsinfo.LogLine {
+	return gs.process.Logs()
+}
+
+// FollowLogs returns recent output lines and a channel of new lines
+// until the process exits or cancel is called.
+func (gs *GS) FollowLogs() ([]gsinfo.LogLine, <-chan gsinfo.LogLine, func()) {
+	return gs.process.FollowLogs()
+}
+
+// ProcessStat returns the latest /proc sample of the process.
+func (gs *GS) ProcessStat() *procstat.ProcessStat {
+	return gs.process.Stat()
+}
+
+// Kill ends the process with SIGKILL without asking gs,
+// for runaway processes which would not shut down gracefully.
+// a process ending gracefully can still be killed, once.
+// returns false when the process is killed already.
+func (gs *GS) Kill(reason string) bool {
+	if !gs.killed.CompareAndSwap(false, true) {
+		return false
+	}
+
+	gs.ending.Store(true)
+	gs.transit(gsstate.ShuttingDown, reason)
+	gs.process.Close()
+	return true
+}
+
+func (gs *GS) Info() gsinfo.GSInfo {
+	i := gsinfo.GSInfo{
+		Index:       gs.params.Index(),
+		Executable:  gs.params.Executable(),
+		ProcessName: gs.params.ProcessName(),
+		Id:          gs.params.UuidString(),
+		Port:        gs.params.Port().Number(),
+		Ports:       gs.params.PortNumbers(),
+		Pid:         gs.process.Pid(),
+		Process:     gs.process.Stat(),
+		Exit:        gs.Exit(),
 		Summary: gsinfo.MonitoringSummary{
 			ConnectionCount:    gs.lastConnectionCount.Load(),
 			SessionCount:       gs.lastSessionCount.Load(),
 			ActiveSessionCount: gs.lastActiveSessionCount.Load(),
 		},
-		Fatal: gs.fatal.Load(),
+		Allocated:   gs.allocated.Load(),
+		Restored:    gs.restored,
+		Ending:      gs.ending.Load(),
+		Transitions: gs.state.History(),
+	}
+	last := i.Transitions[len(i.Transitions)-1]
+	i.State = last.To
+	i.StateReason = last.Reason
+	i.TimeStateChanged = last.Time
+	for _, t := range i.Transitions {
+		if t.To == gsstate.Failed {
+			i.Fatal = true
+			break
+		}
 	}
+
 	var ptr *time.Time
 	if ptr = gs.timeStarted; ptr != nil {
 		i.Summary.TimeStarted = *ptr
@@ -99,6 +436,9 @@ func (gs *GS) Info() gsinfo.GSInfo {
 	if ptr = gs.timeEstablished.Load(); ptr != nil {
 		i.Summary.TimeEstablished = *ptr
 	}
+	if ptr = gs.timeAllocated.Load(); ptr != nil {
+		i.Summary.TimeAllocated = *ptr
+	}
 	if ptr = gs.timeLastCommunicate.Load(); ptr != nil {
 		i.Summary.TimeLastCommunicate = *ptr
 	}
@@ -110,6 +450,49 @@ func (gs *GS) Established() bool {
 	return gs.conn != nil
 }
 
+func (gs *GS) Allocated() bool {
+	return gs.allocated.Load()
+}
+
+// Allocate marks the gs as handed out to clients.
+// a gs that is still starting becomes Allocated when established.
+// returns false when the gs was already allocated or is not available.
+func (gs *GS) Allocate() bool {
+	s := gs.state.Current()
+	if s != gsstate.Starting && s != gsstate.Ready {
+		return false
+	}
+	if !gs.allocated.CompareAndSwap(false, true) {
+		return false
+	}
+
+	now := time.Now()
+	gs.timeAllocated.Store(&now)
+	if s == gsstate.Ready {
+		if err := gs.state.TransitFrom(
+			gsstate.Ready,
+			gsstate.Allocated,
+			"allocated from warm pool",
+		); err != nil {
+			gs.allocated.Store(false)
+			return false
+		}
+	}
+	return true
+}
+
+// ClaimConnection reserves the only monitoring connection of gs,
+// so that concurrent handshakes for the same gs can not both succeed.
+func (gs *GS) ClaimConnection() bool {
+	return gs.connClaimed.CompareAndSwap(false, true)
+}
+
+func (gs *GS) ReleaseConnection() {
+	if gs.conn == nil {
+		gs.connClaimed.Store(false)
+	}
+}
+
 func (gs *GS) StartListen(conn *websocket.Conn) {
 	if conn == nil || gs.conn != nil {
 		return
@@ -118,6 +501,19 @@ func (gs *GS) StartListen(conn *websocket.Conn) {
 	now := time.Now()
 	gs.timeEstablished.Store(&now)
 	gs.conn = conn
+
+	if !gs.restored {
+		gs.metrics.EstablishLatency.Observe(
+			now.Sub(*gs.timeStarted).Seconds(),
+			gs.MetricLabels()...,
+		)
+	}
+
+	if gs.allocated.Load() {
+		gs.transit(gsstate.Allocated, "established")
+	} else {
+		gs.transit(gsstate.Ready, "established")
+	}
 }
 
 func (gs *GS) wait() {
@@ -169,23 +565,50 @@ LOOP:
 					err.Error(),
 				)
 				connectionBroken = true
-				gs.fatal.Store(true)
+				gs.metrics.Disconnects.Inc(gs.MetricLabels()...)
+				if !gs.ending.Load() {
+					gs.transit(gsstate.Failed, ReasonConnectionBroken)
+				}
 				continue
 			}
 
 			if !bytes.Equal(m.GuidRaw, gs.params.UuidRaw()) {
 				gs.logger.Warn(gs.params.LogWithId("received broken uuid"))
 				connectionBroken = true
-				gs.fatal.Store(true)
+				gs.transit(gsstate.Failed, ReasonBrokenUuid)
 				continue
 			}
 
 			now := time.Now()
 			gs.timeLastCommunicate.Store(&now)
 
+			if m.MessageType == monitor.MessageCommandReply {
+				if ch, ok := gs.pendingReply.Load(m.RequestId); ok {
+					select {
+					case ch.(chan *monitor.MonitoringMessage) <- &m:
+					default:
+					}
+				} else {
+					gs.logger.Warnf(gs.params.LogWithId(
+						"received reply for unknown request id: %s"),
+						m.RequestId,
+					)
+				}
+				continue
+			}
+
+			if m.MessageType == monitor.MessageShutdownAck {
+				select {
+				case gs.shutdownAckCh <- true:
+				default:
+				}
+				continue
+			}
+
 			if m.ErrorCode == monitor.ErrorFatal {
 				gs.logger.Error(gs.params.LogWithId(string(m.ErrorUtf8)))
-				gs.fatal.Store(true)
+				gs.metrics.FatalReports.Inc(gs.MetricLabels()...)
+				gs.transit(gsstate.Failed, ReasonFatalReported+string(m.ErrorUtf8))
 				continue
 			} else if m.ErrorCode == monitor.ErrorWarn {
 				gs.logger.Warn(gs.params.LogWithId(string(m.ErrorUtf8)))
@@ -58,6 +58,24 @@ func (m *GSMap) Item(id string) (*gs.GS, error) {
 	}
 }
 
+func (m *GSMap) Range(f func(id string, gs *gs.GS) bool) error {
+	var err error
+	m.inner.Range(func(k interface{}, v interface{}) bool {
+		id, ok := k.(string)
+		if !ok {
+			err = ErrorCastFail
+			return false
+		}
+		gs, ok := v.(*gs.GS)
+		if !ok {
+			err = ErrorCastFail
+			return false
+		}
+		return f(id, gs)
+	})
+	return err
+}
+
 func (m *GSMap) UnsortedInfo() (*gsinfo.AllGSInfo, error) {
 	info := &gsinfo.AllGSInfo{
 		Count: int64(m.count),
@@ -0,0 +1,39 @@
+package gsinfo
+
+import "time"
+
+// classes of process exits
+const (
+	// exited with code 0 on its own
+	ExitClean = "clean"
+	// lift sent SIGTERM or SIGKILL
+	ExitStopped = "stopped"
+	// non zero code or signal lift did not send
+	ExitCrashed = "crashed"
+	ExitOOM     = "oom"
+	// adopted process, its wait status is not available
+	ExitUnknown = "unknown"
+)
+
+// ExitInfo is a record of an exited process.
+type ExitInfo struct {
+	Id          string
+	Executable  string
+	ProcessName string
+	Pid         int
+
+	Class  string
+	Reason string
+	// -1 when killed by signal or unknown
+	Code   int
+	Signal string
+	// lift terminated or killed the process
+	Initiated bool
+
+	TimeStarted time.Time
+	TimeExited  time.Time
+	RuntimeSec  float64
+
+	// last lines of stderr, empty for adopted processes
+	StderrTail []string
+}
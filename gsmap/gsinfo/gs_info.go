@@ -1,10 +1,15 @@
 package gsinfo
 
-import "time"
+import (
+	"lift/gsmap/gsstate"
+	"lift/procstat"
+	"time"
+)
 
 type MonitoringSummary struct {
 	TimeStarted         time.Time
 	TimeEstablished     time.Time
+	TimeAllocated       time.Time
 	TimeLastCommunicate time.Time
 
 	ConnectionCount    int64
@@ -13,11 +18,40 @@ type MonitoringSummary struct {
 }
 
 type GSInfo struct {
-	Index   int
-	Id      string
-	Port    uint16
-	Summary MonitoringSummary
-	Fatal   bool
+	// index at launch, it changes when GSExecutables are reordered
+	Index       int
+	Executable  string
+	ProcessName string
+	Id          string
+	Port        uint16
+	Ports       map[string]uint16
+	Summary     MonitoringSummary
+	// the gs has been Failed, kept after it moved on to shutting down
+	Fatal     bool
+	Allocated bool
+	Restored  bool
+	// shutdown has been started, the state may still be Draining
+	Ending bool
+
+	Pid int
+	// sampled from /proc, null until first sampled
+	Process *procstat.ProcessStat
+	// null until exited
+	Exit *ExitInfo
+
+	State            gsstate.State
+	StateReason      string
+	TimeStateChanged time.Time
+	Transitions      []gsstate.Transition
+}
+
+// LogLine is a line of process output.
+type LogLine struct {
+	// increases by one per line, gaps mean dropped lines
+	Seq    uint64
+	Time   time.Time
+	Stream string
+	Line   string
 }
 
 type AllGSInfo struct {
@@ -28,14 +62,18 @@ type AllGSInfo struct {
 
 type GSClass struct {
 	Name           string
+	ProcessName    string
 	Index          int64
 	Capacity       int64
 	MaxBackfillSec int64
+	WarmPoolSize   int64
 }
 
 type GSPort struct {
-	Id   string
-	Port uint16
+	Id string
+	// the primary port, same as the first of Ports
+	Port  uint16
+	Ports map[string]uint16
 }
 
 type GSBackfillPort struct {
@@ -0,0 +1,254 @@
+package gslog
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Ext = ".log"
+	// suffix of rotated files, sortable in time order
+	RotatedTimeFormat = "20060102T150405.000000"
+	LineTimeFormat    = time.RFC3339Nano
+)
+
+// streams of process output
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+var (
+	uuidPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+)
+
+// Params of log files of processes, zero values disable each limit.
+type Params struct {
+	// empty disables log files, output goes to lift log
+	Dir string
+	// the file is rotated when it exceeds MaxSize bytes or gets older than MaxAge
+	MaxSize int64
+	MaxAge  time.Duration
+	// rotated files kept per process
+	MaxBackups int
+	// files of exited processes not written for Retention are deleted
+	Retention time.Duration
+}
+
+func (p Params) Enabled() bool {
+	return p.Dir != ""
+}
+
+// Writer writes output lines of a process to its own file with rotation.
+type Writer struct {
+	params Params
+	// file name without extension, like executable-uuid
+	name string
+	// the process writes its stream files by itself
+	detached bool
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	timeOpen time.Time
+}
+
+// NewWriter opens the log file of the process in params.Dir,
+// named by executable and id of the process.
+func NewWriter(params Params, executable string, id string) (*Writer, error) {
+	if err := os.MkdirAll(params.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		params: params,
+		name:   executable + "-" + id,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewDetached returns output of a process which writes its files by itself,
+// so the output does not go through pipes of the lift which started it and
+// the process can outlive that lift. the files are named like
+// executable-uuid.stdout.log and they are not rotated, since the process
+// holds them open. output is discarded when log files are disabled.
+func NewDetached(params Params, executable string, id string) (*Writer, error) {
+	if params.Enabled() {
+		if err := os.MkdirAll(params.Dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Writer{
+		params:   params,
+		name:     executable + "-" + id,
+		detached: true,
+	}, nil
+}
+
+// Enabled tells the output is written to files.
+func (w *Writer) Enabled() bool {
+	return w.params.Enabled()
+}
+
+func (w *Writer) Detached() bool {
+	return w.detached
+}
+
+// StreamPath returns the file a detached process writes the stream to,
+// os.DevNull when log files are disabled.
+func (w *Writer) StreamPath(stream string) string {
+	if !w.params.Enabled() {
+		return os.DevNull
+	}
+	return filepath.Join(w.params.Dir, w.name+"."+stream+Ext)
+}
+
+// OpenStream opens the file of the stream for the detached process to write.
+func (w *Writer) OpenStream(stream string) (*os.File, error) {
+	return os.OpenFile(w.StreamPath(stream), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// Path returns the path of the current file.
+func (w *Writer) Path() string {
+	return filepath.Join(w.params.Dir, w.name+Ext)
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.Path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.size = info.Size()
+	w.timeOpen = time.Now()
+	return nil
+}
+
+// WriteLine writes a line of the stream with time, rotating first when needed.
+func (w *Writer) WriteLine(stream string, line string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	if w.needRotate() {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC().Format(LineTimeFormat)
+	n, err := w.file.WriteString(now + " " + stream + " " + strings.TrimRight(line, "\n") + "\n")
+	w.size += int64(n)
+	return err
+}
+
+func (w *Writer) needRotate() bool {
+	if w.size == 0 {
+		return false
+	}
+	return (w.params.MaxSize > 0 && w.size >= w.params.MaxSize) ||
+		(w.params.MaxAge > 0 && time.Since(w.timeOpen) >= w.params.MaxAge)
+}
+
+// rotate renames the current file with time suffix and opens new one,
+// then removes backups beyond MaxBackups.
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	rotated := filepath.Join(
+		w.params.Dir,
+		w.name+"-"+time.Now().UTC().Format(RotatedTimeFormat)+Ext,
+	)
+	if err := os.Rename(w.Path(), rotated); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	return w.removeBackups()
+}
+
+func (w *Writer) removeBackups() error {
+	if w.params.MaxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(filepath.Join(w.params.Dir, w.name+"-*"+Ext))
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > w.params.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Prune deletes log files in dir not written for retention,
+// files of live processes are kept regardless.
+// returns the number of deleted files.
+func Prune(dir string, retention time.Duration, live func(id string) bool) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), Ext) {
+			continue
+		}
+		id := uuidPattern.FindString(e.Name())
+		if id == "" || live(id) {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) < retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -3,36 +3,60 @@ package gsparams
 import (
 	"fmt"
 	"lift/brain/portman/port"
+	"lift/gsmap/monitor"
+	"strconv"
 	"time"
 
 	libuuid "github.com/google/uuid"
 )
 
 type GSParams struct {
-	index   int
-	process string
-	uuid    [16]byte
-	address string
-	port    port.Port
+	index      int
+	executable string
+	process    string
+	uuid       [16]byte
+	address    string
+	ports      []port.NamedPort
+	secret     string
+
+	callbackBase string
+	template     *Template
 
 	monitoringTimeout time.Duration
+	shutdownGrace     time.Duration
+	terminateTimeout  time.Duration
 }
 
 func NewGSParams(
 	index int,
+	executable string,
 	process string,
 	uuid [16]byte,
 	address string,
-	port port.Port,
+	ports []port.NamedPort,
+	secret string,
+	callbackBase string,
+	template *Template,
 	monitoringTimeout time.Duration,
+	shutdownGrace time.Duration,
+	terminateTimeout time.Duration,
 ) *GSParams {
+	if template == nil {
+		template = &Template{}
+	}
 	return &GSParams{
 		index:             index,
+		executable:        executable,
 		process:           process,
 		uuid:              uuid,
 		address:           address,
-		port:              port,
+		ports:             ports,
+		secret:            secret,
+		callbackBase:      callbackBase,
+		template:          template,
 		monitoringTimeout: monitoringTimeout,
+		shutdownGrace:     shutdownGrace,
+		terminateTimeout:  terminateTimeout,
 	}
 }
 
@@ -40,6 +64,10 @@ func (p *GSParams) Index() int {
 	return p.index
 }
 
+func (p *GSParams) Executable() string {
+	return p.executable
+}
+
 func (p *GSParams) ProcessName() string {
 	return p.process
 }
@@ -52,22 +80,79 @@ func (p *GSParams) UuidRaw() []byte {
 	return p.uuid[:]
 }
 
+// Port returns the primary port, the first slot.
 func (p *GSParams) Port() port.Port {
-	return p.port
+	return p.ports[0].Port
 }
 
-func (p *GSParams) ToArgs() []string {
-	return []string{
-		"-a", p.address,
-		"-p", p.port.String(),
-		"-u", p.UuidString(),
+func (p *GSParams) Ports() []port.NamedPort {
+	return p.ports
+}
+
+// PortNumbers returns port numbers by slot name.
+func (p *GSParams) PortNumbers() map[string]uint16 {
+	m := make(map[string]uint16, len(p.ports))
+	for _, np := range p.ports {
+		m[np.Name] = np.Port.Number()
+	}
+	return m
+}
+
+func (p *GSParams) Secret() string {
+	return p.secret
+}
+
+// CallbackURL is where the gs connects to lift.
+func (p *GSParams) CallbackURL() string {
+	return p.callbackBase + monitor.ConnectPath + p.UuidString()
+}
+
+func (p *GSParams) WorkDir() string {
+	return p.template.WorkDir
+}
+
+func (p *GSParams) placeholders() map[string]string {
+	m := map[string]string{
+		PlaceholderAddress:     p.address,
+		PlaceholderPort:        p.Port().String(),
+		PlaceholderUuid:        p.UuidString(),
+		PlaceholderIndex:       strconv.Itoa(p.index),
+		PlaceholderExecutable:  p.executable,
+		PlaceholderCallbackURL: p.CallbackURL(),
+		PlaceholderSecret:      p.secret,
+	}
+	for _, np := range p.ports {
+		m[PlaceholderPortPrefix+np.Name+"}"] = np.Port.String()
 	}
+	return m
+}
+
+func (p *GSParams) ToArgs() []string {
+	return p.template.expandArgs(p.placeholders())
+}
+
+// ToEnv returns variables of the template, the callback url and the secret,
+// lift variables come last so that the template can not override them.
+func (p *GSParams) ToEnv() []string {
+	return append(
+		p.template.expandEnv(p.placeholders()),
+		monitor.EnvCallbackURL+"="+p.CallbackURL(),
+		monitor.EnvSecret+"="+p.secret,
+	)
 }
 
 func (p *GSParams) NextMonitoringTimeout() time.Time {
 	return time.Now().Add(p.monitoringTimeout)
 }
 
+func (p *GSParams) ShutdownGrace() time.Duration {
+	return p.shutdownGrace
+}
+
+func (p *GSParams) TerminateTimeout() time.Duration {
+	return p.terminateTimeout
+}
+
 func (p *GSParams) LogWithId(msg string) string {
 	return fmt.Sprintf("GS PROCESS [%s] ", p.UuidString()) + msg
 }
@@ -0,0 +1,80 @@
+package gsparams
+
+import (
+	"sort"
+	"strings"
+)
+
+// placeholders replaced in Args and Env values on launch
+const (
+	PlaceholderAddress = "{address}"
+	// the primary port, each slot is referred as {port.NAME}
+	PlaceholderPort        = "{port}"
+	PlaceholderPortPrefix  = "{port."
+	PlaceholderUuid        = "{uuid}"
+	PlaceholderIndex       = "{index}"
+	PlaceholderExecutable  = "{executable}"
+	PlaceholderCallbackURL = "{callback_url}"
+	// the secret in arguments is readable by any local user,
+	// prefer the environment variable lift always sets.
+	PlaceholderSecret = "{secret}"
+	// custom values are referred as {var.NAME}
+	PlaceholderVarPrefix = "{var."
+)
+
+var (
+	DefaultArgs = []string{
+		"-a", PlaceholderAddress,
+		"-p", PlaceholderPort,
+		"-u", PlaceholderUuid,
+	}
+)
+
+// Template describes how an executable is launched.
+type Template struct {
+	Args      []string
+	ExtraArgs []string
+	Env       map[string]string
+	Vars      map[string]string
+	WorkDir   string
+}
+
+func (t *Template) args() []string {
+	if len(t.Args) == 0 {
+		return DefaultArgs
+	}
+	return t.Args
+}
+
+func (t *Template) replacer(values map[string]string) *strings.Replacer {
+	pairs := make([]string, 0, (len(values)+len(t.Vars))*2)
+	for k, v := range values {
+		pairs = append(pairs, k, v)
+	}
+	for k, v := range t.Vars {
+		pairs = append(pairs, PlaceholderVarPrefix+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// expandArgs replaces placeholders in Args, ExtraArgs are appended as they are.
+func (t *Template) expandArgs(values map[string]string) []string {
+	r := t.replacer(values)
+	src := t.args()
+	args := make([]string, 0, len(src)+len(t.ExtraArgs))
+	for _, a := range src {
+		args = append(args, r.Replace(a))
+	}
+	return append(args, t.ExtraArgs...)
+}
+
+// expandEnv replaces placeholders in Env values, sorted by name.
+func (t *Template) expandEnv(values map[string]string) []string {
+	r := t.replacer(values)
+	env := make([]string, 0, len(t.Env))
+	for k, v := range t.Env {
+		env = append(env, k+"="+r.Replace(v))
+	}
+	sort.Strings(env)
+	return env
+}
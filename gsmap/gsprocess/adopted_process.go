@@ -0,0 +1,148 @@
+package gsprocess
+
+import (
+	"bytes"
+	"errors"
+	"lift/cgroup"
+	"lift/gsmap/gsinfo"
+	"lift/gsmap/gslog"
+	"lift/gsmap/gsparams"
+	"lift/gsmap/monitor"
+	"lift/logger"
+	"lift/procstat"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	AdoptedPollInterval = time.Second
+)
+
+var (
+	ErrorNotAdoptable = errors.New("process is not running or not the expected gs")
+)
+
+// AdoptGSProcess wraps a process which was launched by previous lift.
+// the process is not a child of this lift, so it is polled instead of waited,
+// and its output is read from its detached output files when output is not nil.
+func AdoptGSProcess(
+	params *gsparams.GSParams,
+	pid int,
+	cg *cgroup.Cgroup,
+	output *gslog.Writer,
+	l logger.Logger,
+) (*GSProcess, error) {
+	if !running(pid, params) {
+		return nil, ErrorNotAdoptable
+	}
+
+	b := &atomic.Bool{}
+	b.Store(true)
+	return &GSProcess{
+		pid:             pid,
+		stat:            &atomic.Pointer[procstat.ProcessStat]{},
+		cgroup:          cg,
+		output:          output,
+		params:          params,
+		logger:          l,
+		cancelProcess:   func() {},
+		canceled:        b,
+		onProcessClosed: nil,
+		initiated:       &atomic.Bool{},
+		exit:            &atomic.Pointer[gsinfo.ExitInfo]{},
+		stderrTail:      &lineTail{},
+		logs:            newLogBuffer(),
+	}, nil
+}
+
+// running checks the pid is still alive and is not reused by others,
+// by the uuid in its command line or the secret in its environment,
+// args template may not contain the uuid.
+func running(pid int, params *gsparams.GSParams) bool {
+	return runningAs(pid, params.UuidString(), params.Secret())
+}
+
+func runningAs(pid int, uuid string, secret string) bool {
+	dir := "/proc/" + strconv.Itoa(pid)
+	b, err := os.ReadFile(dir + "/cmdline")
+	if err != nil {
+		return false
+	}
+	if bytes.Contains(b, []byte(uuid)) {
+		return true
+	}
+
+	b, err = os.ReadFile(dir + "/environ")
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(b, []byte(monitor.EnvSecret+"="+secret))
+}
+
+// KillOrphan kills a process launched by previous lift which can not be
+// adopted, so that it does not keep running untracked, and waits for it
+// to exit up to AdoptedPollInterval. nothing is done when the pid is gone.
+func KillOrphan(pid int, uuid string, secret string) error {
+	if !runningAs(pid, uuid, secret) {
+		return nil
+	}
+	if err := signalGroup(pid, syscall.SIGKILL); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(AdoptedPollInterval)
+	for runningAs(pid, uuid, secret) && time.Now().Before(deadline) {
+		time.Sleep(AdoptedPollInterval / 10)
+	}
+	return nil
+}
+
+func (p *GSProcess) Pid() int {
+	return p.pid
+}
+
+func (p *GSProcess) adopted() bool {
+	return p.cmd == nil
+}
+
+// signal sends sig to the process group, so that children of the gs
+// do not outlive it.
+func (p *GSProcess) signal(sig syscall.Signal) error {
+	if !p.adopted() {
+		// the pid is not reused until the process is waited
+		if err := p.cmd.Process.Signal(syscall.Signal(0)); err != nil {
+			return err
+		}
+	}
+	return signalGroup(p.pid, sig)
+}
+
+// signalGroup sends sig to the group the process leads, processes are
+// launched with Setpgid, or to the process alone when it is not the leader.
+func signalGroup(pid int, sig syscall.Signal) error {
+	if pgid, err := syscall.Getpgid(pid); err == nil && pgid == pid {
+		return syscall.Kill(-pid, sig)
+	}
+	return syscall.Kill(pid, sig)
+}
+
+func (p *GSProcess) poll() {
+	ticker := time.NewTicker(AdoptedPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !running(p.pid, p.params) {
+			break
+		}
+	}
+
+	p.canceled.Store(true)
+	p.stopTail()
+	p.exited(nil)
+	p.logs.close()
+	p.logger.Debug(p.params.LogWithId("adopted gs process successfully closed"))
+	p.onProcessClosed()
+}
@@ -0,0 +1,110 @@
+package gsprocess
+
+import (
+	"bufio"
+	"io"
+	"lift/gsmap/gslog"
+	"os"
+	"time"
+)
+
+const (
+	// detached output files are read for new lines at this interval
+	TailInterval = time.Millisecond * 200
+)
+
+func (p *GSProcess) detached() bool {
+	return p.output != nil && p.output.Detached()
+}
+
+// openStreams connects output of the process to its files directly,
+// the returned files are closed by the caller after the process started.
+func (p *GSProcess) openStreams() ([]*os.File, error) {
+	stdout, err := p.output.OpenStream(gslog.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := p.output.OpenStream(gslog.Stderr)
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+
+	p.cmd.Stdout = stdout
+	p.cmd.Stderr = stderr
+	return []*os.File{stdout, stderr}, nil
+}
+
+// startTail reads lines written to detached output files into memory,
+// adopted processes are read from the end of files.
+func (p *GSProcess) startTail(fromEnd bool) {
+	if !p.detached() || !p.output.Enabled() {
+		return
+	}
+
+	p.tailDone = make(chan bool)
+	p.tailWait.Add(2)
+	go p.tail(gslog.Stdout, fromEnd)
+	go p.tail(gslog.Stderr, fromEnd)
+}
+
+// stopTail reads the rest of files after the process exited.
+func (p *GSProcess) stopTail() {
+	if p.tailDone == nil {
+		return
+	}
+	close(p.tailDone)
+	p.tailWait.Wait()
+}
+
+func (p *GSProcess) tail(stream string, fromEnd bool) {
+	defer p.tailWait.Done()
+
+	f, err := os.Open(p.output.StreamPath(stream))
+	if err != nil {
+		p.logger.Warnf(p.params.LogWithId("%s: failed to open output file"), err.Error())
+		return
+	}
+	defer f.Close()
+
+	if fromEnd {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			p.logger.Warnf(p.params.LogWithId("%s: failed to seek output file"), err.Error())
+			return
+		}
+	}
+
+	ticker := time.NewTicker(TailInterval)
+	defer ticker.Stop()
+
+	errLog := stream == gslog.Stderr
+	reader := bufio.NewReader(f)
+	partial := ""
+	done := false
+	for {
+		line, err := reader.ReadString('\n')
+		partial += line
+		if err == nil {
+			p.keepOutput(errLog, partial)
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			p.logger.Warnf(p.params.LogWithId("%s: failed to read output file"), err.Error())
+			return
+		}
+		if done {
+			if partial != "" {
+				p.keepOutput(errLog, partial)
+			}
+			return
+		}
+
+		select {
+		case <-p.tailDone:
+			// read once more to the end
+			done = true
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package gsprocess
+
+import (
+	"lift/gsmap/gsinfo"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	StderrTailLines = 20
+)
+
+// lineTail keeps the last lines written.
+type lineTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (t *lineTail) add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.lines) == StderrTailLines {
+		t.lines = t.lines[1:]
+	}
+	t.lines = append(t.lines, line)
+}
+
+func (t *lineTail) copy() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append(make([]string, 0, len(t.lines)), t.lines...)
+}
+
+// exited records the exit and removes the cgroup,
+// state is nil for adopted processes.
+func (p *GSProcess) exited(state *os.ProcessState) {
+	e := &gsinfo.ExitInfo{
+		Id:          p.params.UuidString(),
+		Executable:  p.params.Executable(),
+		ProcessName: p.params.ProcessName(),
+		Pid:         p.pid,
+		Code:        -1,
+		Initiated:   p.initiated.Load(),
+		TimeExited:  time.Now(),
+		StderrTail:  p.stderrTail.copy(),
+	}
+	if state != nil {
+		e.Code = state.ExitCode()
+		if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			e.Signal = ws.Signal().String()
+		}
+	}
+
+	oom := false
+	if p.cgroup != nil {
+		n, err := p.cgroup.OOMKills()
+		if err != nil {
+			p.logger.Warnf(p.params.LogWithId("%s: failed to read oom events"), err.Error())
+		}
+		oom = n > 0
+		p.removeCgroup()
+	}
+
+	switch {
+	case oom:
+		e.Class, e.Reason = gsinfo.ExitOOM, "killed by oom killer"
+	case e.Initiated:
+		e.Class, e.Reason = gsinfo.ExitStopped, "stopped by lift"
+	case state == nil:
+		e.Class, e.Reason = gsinfo.ExitUnknown, "process exited"
+	case e.Signal != "":
+		e.Class, e.Reason = gsinfo.ExitCrashed, "killed by signal: "+e.Signal
+	case e.Code == 0:
+		e.Class, e.Reason = gsinfo.ExitClean, "exited with code 0"
+	default:
+		e.Class, e.Reason = gsinfo.ExitCrashed, "exited with code "+strconv.Itoa(e.Code)
+	}
+	p.exit.Store(e)
+}
+
+// removeCgroup kills processes left in the cgroup after the gs exited,
+// then removes the cgroup.
+func (p *GSProcess) removeCgroup() {
+	if err := p.cgroup.Kill(); err != nil {
+		p.logger.Warnf(p.params.LogWithId("%s: failed to kill processes in cgroup"), err.Error())
+	}
+	if err := p.cgroup.Remove(); err != nil {
+		p.logger.Warnf(p.params.LogWithId("%s: failed to remove cgroup"), err.Error())
+	}
+}
+
+// Exit returns the record of the exit, nil while running.
+func (p *GSProcess) Exit() *gsinfo.ExitInfo {
+	return p.exit.Load()
+}
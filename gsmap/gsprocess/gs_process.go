@@ -1,162 +1,226 @@
 package gsprocess
 
 import (
-	"bufio"
 	"context"
-	"io"
+	"errors"
+	"lift/cgroup"
+	"lift/gsmap/gsinfo"
+	"lift/gsmap/gslog"
 	"lift/gsmap/gsparams"
 	"lift/logger"
+	"lift/procstat"
+	"os"
 	"os/exec"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type GSProcess struct {
 	cmd    *exec.Cmd
+	pid    int
+	stat   *atomic.Pointer[procstat.ProcessStat]
+	cgroup *cgroup.Cgroup
+	// nil means output goes to logger,
+	// detached output is written to files by the process itself
+	output *gslog.Writer
 	params *gsparams.GSParams
-	stdout io.ReadCloser
-	stderr io.ReadCloser
+	stdout *lineWriter
+	stderr *lineWriter
 	logger logger.Logger
 
 	cancelProcess   context.CancelFunc
 	canceled        *atomic.Bool
 	onProcessClosed func()
-
-	closingWait sync.WaitGroup
-	closeChLog  chan bool
-	closeChErr  chan bool
+	// lift sent SIGTERM or SIGKILL
+	initiated *atomic.Bool
+	// set before onProcessClosed is called
+	exit       *atomic.Pointer[gsinfo.ExitInfo]
+	stderrTail *lineTail
+	logs       *logBuffer
+
+	// closed when the process exited to finish reading detached output
+	tailDone chan bool
+	tailWait sync.WaitGroup
 }
 
-var (
-	ErrorMessageOnKill = "signal: killed"
+const (
+	StatInterval = time.Second * 5
+	// output is drained at most this long after the process exits,
+	// descendants holding the pipes cannot keep the process unreaped
+	OutputWaitDelay = time.Second * 2
 )
 
-func NewGSProcess(params *gsparams.GSParams, l logger.Logger) (*GSProcess, error) {
+// NewGSProcess prepares the process, it is placed in cg when cg is not nil,
+// and its output is written to output when output is not nil.
+// the process gets its own process group, so signals to lift such as SIGINT
+// from terminal are not delivered to it.
+func NewGSProcess(
+	params *gsparams.GSParams,
+	cg *cgroup.Cgroup,
+	output *gslog.Writer,
+	l logger.Logger,
+) (*GSProcess, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cmd := exec.CommandContext(ctx, params.ProcessName(), params.ToArgs()...)
+	cmd.Env = append(os.Environ(), params.ToEnv()...)
+	cmd.Dir = params.WorkDir()
+	cmd.WaitDelay = OutputWaitDelay
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	b := &atomic.Bool{}
 	b.Store(true)
 	p := &GSProcess{
 		cmd:             cmd,
+		stat:            &atomic.Pointer[procstat.ProcessStat]{},
+		cgroup:          cg,
+		output:          output,
 		params:          params,
 		logger:          l,
 		cancelProcess:   cancel,
 		canceled:        b,
 		onProcessClosed: nil,
-		closingWait:     sync.WaitGroup{},
-		closeChLog:      make(chan bool),
-		closeChErr:      make(chan bool),
+		initiated:       &atomic.Bool{},
+		exit:            &atomic.Pointer[gsinfo.ExitInfo]{},
+		stderrTail:      &lineTail{},
+		logs:            newLogBuffer(),
 	}
 
-	outPipe, err := p.cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+	// children share the process group, they are killed together
+	cmd.Cancel = func() error {
+		return p.signal(syscall.SIGKILL)
 	}
-	errPipe, err := p.cmd.StderrPipe()
-	if err != nil {
-		return nil, err
+	p.stdout = &lineWriter{p: p}
+	p.stderr = &lineWriter{p: p, errLog: true}
+	if !p.detached() {
+		cmd.Stdout = p.stdout
+		cmd.Stderr = p.stderr
 	}
-
-	p.stdout = outPipe
-	p.stderr = errPipe
 	return p, nil
 }
 
 func (p *GSProcess) Start(onProcessClosed func()) error {
-	if err := p.cmd.Start(); err != nil {
+	if p.adopted() {
+		p.onProcessClosed = onProcessClosed
+		p.canceled.Store(false)
+		p.startTail(true)
+		go p.poll()
+		go p.sample()
+		return nil
+	}
+
+	var files []*os.File
+	if p.detached() {
+		s, err := p.openStreams()
+		if err != nil {
+			return err
+		}
+		files = s
+	}
+	if p.cgroup != nil {
+		dir, err := p.cgroup.Dir()
+		if err != nil {
+			closeFiles(files)
+			return err
+		}
+		p.cmd.SysProcAttr.UseCgroupFD = true
+		p.cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+		files = append(files, dir)
+	}
+	err := p.cmd.Start()
+	// the process has its own copies
+	closeFiles(files)
+	if err != nil {
 		return err
 	}
+	p.pid = p.cmd.Process.Pid
 	p.onProcessClosed = onProcessClosed
 	p.canceled.Store(false)
-	p.closingWait.Add(2)
-	go p.stdoutLog(true)
-	go p.stdoutLog(false)
+	p.startTail(false)
 	go p.wait()
+	go p.sample()
 	return nil
 }
 
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// sample reads /proc of the process at StatInterval until it is closed.
+func (p *GSProcess) sample() {
+	ticker := time.NewTicker(StatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if p.canceled.Load() {
+			return
+		}
+
+		s, err := procstat.ReadProcess(p.pid, p.stat.Load())
+		if err != nil {
+			p.logger.Debugf(p.params.LogWithId("%s: failed to sample process"), err.Error())
+			continue
+		}
+		p.stat.Store(s)
+	}
+}
+
+// Stat returns the latest sample, nil until first sampled.
+func (p *GSProcess) Stat() *procstat.ProcessStat {
+	return p.stat.Load()
+}
+
+// Terminate asks the process to exit with SIGTERM.
+func (p *GSProcess) Terminate() error {
+	if p.canceled.Load() {
+		return nil
+	}
+
+	p.initiated.Store(true)
+	return p.signal(syscall.SIGTERM)
+}
+
+// Close kills the process and its children with SIGKILL.
 func (p *GSProcess) Close() {
 	if p.canceled.Load() {
 		return
 	}
 
+	p.initiated.Store(true)
+	if p.adopted() {
+		p.canceled.Store(true)
+		if err := p.signal(syscall.SIGKILL); err != nil {
+			p.logger.Warnf(p.params.LogWithId("%s: failed to kill adopted process"), err.Error())
+		}
+		return
+	}
+
 	p.canceled.Store(true)
 	p.cancelProcess()
-	p.closeChLog <- true
-	p.closeChErr <- true
 }
 
 func (p *GSProcess) wait() {
+	// exit status is in ProcessState, output is copied until the pipes
+	// are drained or closed after OutputWaitDelay
 	err := p.cmd.Wait()
-	if err != nil && err.Error() != ErrorMessageOnKill {
-		p.logger.Errorf(p.params.LogWithId(
-			"%s: this means gs process was down first, make sure gs is closed successfully"),
-			err.Error(),
+	if errors.Is(err, exec.ErrWaitDelay) {
+		p.logger.Warnf(p.params.LogWithId(
+			"output pipes were still open %s after exit, descendants of gs process may hold them"),
+			OutputWaitDelay,
 		)
 	}
+	p.stdout.flush()
+	p.stderr.flush()
+	p.stopTail()
+	p.exited(p.cmd.ProcessState)
+
+	p.logExit()
+
 	p.Close()
-	p.closingWait.Wait()
+	p.closeOutput()
+	p.logs.close()
 	p.logger.Debug(p.params.LogWithId("gs process successfully closed"))
 	p.onProcessClosed()
 }
-
-func (p *GSProcess) stdoutLog(errLog bool) {
-	var reader *bufio.Reader
-	if errLog {
-		reader = bufio.NewReader(p.stderr)
-	} else {
-		reader = bufio.NewReader(p.stdout)
-	}
-
-	var closeCh chan bool
-	if errLog {
-		closeCh = p.closeChErr
-	} else {
-		closeCh = p.closeChLog
-	}
-
-	pipeBroken := false
-
-LOOP:
-	for {
-		select {
-		case <-closeCh:
-			break LOOP
-		default:
-			if pipeBroken {
-				continue
-			}
-
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if errLog {
-					p.logger.Errorf(p.params.LogWithId(
-						"error: %s, waiting for closing error logging goroutine"),
-						err.Error(),
-					)
-				} else {
-					p.logger.Errorf(p.params.LogWithId(
-						"error: %s, waiting for closing logging goroutine"),
-						err.Error(),
-					)
-				}
-
-				pipeBroken = true
-				continue
-			}
-
-			if errLog {
-				p.logger.Error(p.params.LogWithId(line))
-			} else {
-				p.logger.Info(p.params.LogWithId(line))
-			}
-		}
-	}
-
-	if errLog {
-		p.logger.Debug(p.params.LogWithId("error logging goroutine successfully closed"))
-	} else {
-		p.logger.Debug(p.params.LogWithId("logging goroutine successfully closed"))
-	}
-	p.closingWait.Done()
-}
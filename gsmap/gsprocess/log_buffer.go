@@ -0,0 +1,99 @@
+package gsprocess
+
+import (
+	"lift/gsmap/gsinfo"
+	"sync"
+	"time"
+)
+
+const (
+	LogBufferLines = 500
+	// lines a follower can lag behind before lines are dropped for it
+	LogFollowBuffer = 256
+)
+
+// logBuffer keeps recent output lines and passes new ones to followers.
+type logBuffer struct {
+	mu        sync.Mutex
+	lines     []gsinfo.LogLine
+	seq       uint64
+	followers map[chan gsinfo.LogLine]bool
+	closed    bool
+}
+
+func newLogBuffer() *logBuffer {
+	return &logBuffer{
+		lines:     make([]gsinfo.LogLine, 0),
+		followers: make(map[chan gsinfo.LogLine]bool),
+	}
+}
+
+func (b *logBuffer) add(stream string, text string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	l := gsinfo.LogLine{
+		Seq:    b.seq,
+		Time:   time.Now(),
+		Stream: stream,
+		Line:   text,
+	}
+	if len(b.lines) == LogBufferLines {
+		b.lines = b.lines[1:]
+	}
+	b.lines = append(b.lines, l)
+
+	for ch := range b.followers {
+		select {
+		case ch <- l:
+		default:
+			// slow follower misses lines, seq tells the gap
+		}
+	}
+}
+
+func (b *logBuffer) copy() []gsinfo.LogLine {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append(make([]gsinfo.LogLine, 0, len(b.lines)), b.lines...)
+}
+
+// follow returns buffered lines and a channel of lines added after them.
+// the channel is closed when the process exits or cancel is called.
+func (b *logBuffer) follow() ([]gsinfo.LogLine, <-chan gsinfo.LogLine, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := append(make([]gsinfo.LogLine, 0, len(b.lines)), b.lines...)
+	ch := make(chan gsinfo.LogLine, LogFollowBuffer)
+	if b.closed {
+		close(ch)
+		return lines, ch, func() {}
+	}
+
+	b.followers[ch] = true
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.followers[ch] {
+			delete(b.followers, ch)
+			close(ch)
+		}
+	}
+	return lines, ch, cancel
+}
+
+// close ends all followers.
+func (b *logBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.followers {
+		delete(b.followers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,120 @@
+package gsprocess
+
+import (
+	"bytes"
+	"lift/gsmap/gsinfo"
+	"lift/gsmap/gslog"
+	"strings"
+)
+
+// lineWriter passes output of the process to writeOutput line by line,
+// it is written only by the copying goroutine of exec.Cmd.
+type lineWriter struct {
+	p      *GSProcess
+	errLog bool
+	buf    []byte
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.p.writeOutput(w.errLog, string(w.buf[:i+1]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// flush writes the last line without newline, called after Wait returned.
+func (w *lineWriter) flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+	w.p.writeOutput(w.errLog, string(w.buf))
+	w.buf = nil
+}
+
+// keepOutput keeps a line of the process in memory.
+func (p *GSProcess) keepOutput(errLog bool, line string) string {
+	text := strings.TrimRight(line, "\n")
+	stream := gslog.Stdout
+	if errLog {
+		stream = gslog.Stderr
+		p.stderrTail.add(text)
+	}
+	p.logs.add(stream, text)
+	return stream
+}
+
+// writeOutput keeps a line of the process in memory and writes it to
+// its log file, or to lift log when log files are disabled or the file failed.
+func (p *GSProcess) writeOutput(errLog bool, line string) {
+	stream := p.keepOutput(errLog, line)
+
+	if p.output != nil {
+		err := p.output.WriteLine(stream, line)
+		if err == nil {
+			return
+		}
+		p.logger.Warnf(p.params.LogWithId("%s: failed to write log file"), err.Error())
+	}
+
+	if errLog {
+		p.logger.Error(p.params.LogWithId(line))
+	} else {
+		p.logger.Info(p.params.LogWithId(line))
+	}
+}
+
+func (p *GSProcess) closeOutput() {
+	if p.output == nil {
+		return
+	}
+	if err := p.output.Close(); err != nil {
+		p.logger.Warnf(p.params.LogWithId("%s: failed to close log file"), err.Error())
+	}
+}
+
+// Logs returns recent output lines, oldest first.
+func (p *GSProcess) Logs() []gsinfo.LogLine {
+	return p.logs.copy()
+}
+
+// FollowLogs returns recent output lines and a channel of new lines,
+// which is closed when the process exits or cancel is called.
+func (p *GSProcess) FollowLogs() ([]gsinfo.LogLine, <-chan gsinfo.LogLine, func()) {
+	return p.logs.follow()
+}
+
+// logExit writes a summary of the exit to lift log.
+// stderr tail is included on crash when output went to log file.
+func (p *GSProcess) logExit() {
+	exit := p.Exit()
+	file := ""
+	switch {
+	case p.detached() && p.output.Enabled():
+		file = ", log files: " + p.output.StreamPath(gslog.Stdout) +
+			", " + p.output.StreamPath(gslog.Stderr)
+	case p.output != nil && !p.detached():
+		file = ", log file: " + p.output.Path()
+	}
+
+	switch exit.Class {
+	case gsinfo.ExitCrashed, gsinfo.ExitOOM:
+		p.logger.Errorf(p.params.LogWithId(
+			"%s: this means gs process was down first, make sure gs is closed successfully%s"),
+			exit.Reason, file,
+		)
+		if p.output == nil {
+			return
+		}
+		for _, line := range exit.StderrTail {
+			p.logger.Error(p.params.LogWithId("stderr tail: " + line))
+		}
+	default:
+		p.logger.Infof(p.params.LogWithId("gs process %s%s"), exit.Reason, file)
+	}
+}
@@ -0,0 +1,144 @@
+package gsstate
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type State uint8
+
+const (
+	// process is launched but not yet connected to monitoring websocket
+	Starting State = iota
+	// established and idle, waiting in warm pool
+	Ready
+	// established and handed out to clients
+	Allocated
+	// asked to finish current sessions, no new clients are routed
+	Draining
+	// lift is closing the process
+	ShuttingDown
+	// process is gone
+	Exited
+	// process reported fatal error or monitoring is broken
+	Failed
+)
+
+var (
+	ErrorInvalidTransition = errors.New("invalid state transition")
+	ErrorUnexpectedState   = errors.New("unexpected current state")
+)
+
+var stateNames = [...]string{
+	Starting:     "Starting",
+	Ready:        "Ready",
+	Allocated:    "Allocated",
+	Draining:     "Draining",
+	ShuttingDown: "ShuttingDown",
+	Exited:       "Exited",
+	Failed:       "Failed",
+}
+
+var transitions = map[State][]State{
+	Starting:     {Ready, Allocated, ShuttingDown, Exited, Failed},
+	Ready:        {Allocated, Draining, ShuttingDown, Exited, Failed},
+	Allocated:    {Draining, ShuttingDown, Exited, Failed},
+	Draining:     {ShuttingDown, Exited, Failed},
+	ShuttingDown: {Exited},
+	Exited:       {},
+	Failed:       {ShuttingDown, Exited},
+}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return fmt.Sprintf("State(%d)", s)
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s State) CanTransit(to State) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+type Transition struct {
+	From   State
+	To     State
+	Reason string
+	Time   time.Time
+}
+
+type StateMachine struct {
+	mu      sync.RWMutex
+	history []Transition
+}
+
+func NewStateMachine(reason string) *StateMachine {
+	return &StateMachine{
+		history: []Transition{{
+			From:   Starting,
+			To:     Starting,
+			Reason: reason,
+			Time:   time.Now(),
+		}},
+	}
+}
+
+func (m *StateMachine) Current() State {
+	return m.Last().To
+}
+
+func (m *StateMachine) Last() Transition {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.history[len(m.history)-1]
+}
+
+func (m *StateMachine) History() []Transition {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	h := make([]Transition, len(m.history))
+	copy(h, m.history)
+	return h
+}
+
+func (m *StateMachine) Transit(to State, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.transit(to, reason)
+}
+
+// TransitFrom transits only when current state is from.
+func (m *StateMachine) TransitFrom(from State, to State, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.history[len(m.history)-1].To != from {
+		return ErrorUnexpectedState
+	}
+	return m.transit(to, reason)
+}
+
+func (m *StateMachine) transit(to State, reason string) error {
+	current := m.history[len(m.history)-1].To
+	if !current.CanTransit(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrorInvalidTransition, current, to)
+	}
+
+	m.history = append(m.history, Transition{
+		From:   current,
+		To:     to,
+		Reason: reason,
+		Time:   time.Now(),
+	})
+	return nil
+}
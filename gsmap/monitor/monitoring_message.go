@@ -1,12 +1,67 @@
 package monitor
 
+import "encoding/json"
+
+const (
+	// environment variable which lift passes per launch secret with.
+	// it is not passed as argument, which any local user can read.
+	EnvSecret = "LIFT_GS_SECRET"
+	// environment variable which lift passes its url for the gs to connect to
+	EnvCallbackURL = "LIFT_CALLBACK_URL"
+	// header to present per launch secret when connecting to lift
+	HeaderSecret = "X-Lift-Secret"
+	// path of lift which gs connects to, followed by its uuid
+	ConnectPath = "/process/connect/"
+)
+
 const (
 	ErrorFatal uint8 = iota
 	ErrorWarn
 	NoError
 )
 
+// message types sent from gs to lift
+const (
+	MessageMonitoring uint8 = iota
+	MessageShutdownAck
+	MessageCommandReply
+)
+
+// command codes sent from lift to gs
+const (
+	CommandShutdown uint8 = iota
+	CommandReserveSlot
+	CommandSetMaxPlayers
+	CommandBroadcastNotice
+	CommandDrain
+	CommandCustom
+)
+
+// reply codes sent from gs to lift with MessageCommandReply
+const (
+	ReplyOk uint8 = iota
+	ReplyRejected
+	ReplyUnsupported
+)
+
+var commandNames = map[string]uint8{
+	"shutdown":         CommandShutdown,
+	"reserve_slot":     CommandReserveSlot,
+	"set_max_players":  CommandSetMaxPlayers,
+	"broadcast_notice": CommandBroadcastNotice,
+	"drain":            CommandDrain,
+	"custom":           CommandCustom,
+}
+
+var replyNames = [...]string{
+	ReplyOk:          "ok",
+	ReplyRejected:    "rejected",
+	ReplyUnsupported: "unsupported",
+}
+
 type MonitoringMessage struct {
+	MessageType uint8
+
 	GuidRaw            []byte
 	ConnectionCount    int64
 	SessionCount       int64
@@ -14,4 +69,43 @@ type MonitoringMessage struct {
 
 	ErrorCode uint8
 	ErrorUtf8 []byte
+
+	RequestId    string
+	ReplyCode    uint8
+	ReplyPayload json.RawMessage
+}
+
+type CommandMessage struct {
+	GuidRaw     []byte
+	RequestId   string
+	CommandCode uint8
+	Payload     json.RawMessage
+}
+
+type ReserveSlotPayload struct {
+	PlayerIds []string
+}
+
+type SetMaxPlayersPayload struct {
+	MaxPlayers int64
+}
+
+type BroadcastNoticePayload struct {
+	Message string
+}
+
+func CommandCodeFromName(name string) (uint8, bool) {
+	code, ok := commandNames[name]
+	return code, ok
+}
+
+func ReplyCodeName(code uint8) string {
+	if int(code) < len(replyNames) {
+		return replyNames[code]
+	}
+	return "unknown"
+}
+
+func (m *CommandMessage) DecodePayload(v interface{}) error {
+	return json.Unmarshal(m.Payload, v)
 }
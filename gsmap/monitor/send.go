@@ -0,0 +1,71 @@
+package monitor
+
+import "encoding/json"
+
+// JSONConn is satisfied by *websocket.Conn of gorilla/websocket.
+// writes should not be called concurrently.
+type JSONConn interface {
+	ReadJSON(v interface{}) error
+	WriteJSON(v interface{}) error
+}
+
+func SendMonitoring(
+	conn JSONConn,
+	guidRaw []byte,
+	connectionCount int64,
+	sessionCount int64,
+	activeSessionCount int64,
+) error {
+	return conn.WriteJSON(&MonitoringMessage{
+		MessageType:        MessageMonitoring,
+		GuidRaw:            guidRaw,
+		ConnectionCount:    connectionCount,
+		SessionCount:       sessionCount,
+		ActiveSessionCount: activeSessionCount,
+		ErrorCode:          NoError,
+	})
+}
+
+func SendShutdownAck(conn JSONConn, guidRaw []byte) error {
+	return conn.WriteJSON(&MonitoringMessage{
+		MessageType: MessageShutdownAck,
+		GuidRaw:     guidRaw,
+		ErrorCode:   NoError,
+	})
+}
+
+// SendReply replies to the command received with ReadCommand.
+// payload is encoded as json, nil sends no payload.
+func SendReply(
+	conn JSONConn,
+	guidRaw []byte,
+	command *CommandMessage,
+	replyCode uint8,
+	payload interface{},
+) error {
+	var raw json.RawMessage
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		raw = b
+	}
+
+	return conn.WriteJSON(&MonitoringMessage{
+		MessageType:  MessageCommandReply,
+		GuidRaw:      guidRaw,
+		ErrorCode:    NoError,
+		RequestId:    command.RequestId,
+		ReplyCode:    replyCode,
+		ReplyPayload: raw,
+	})
+}
+
+func ReadCommand(conn JSONConn) (*CommandMessage, error) {
+	m := &CommandMessage{}
+	if err := conn.ReadJSON(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import "io"
+
+var (
+	EstablishLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+)
+
+// LiftMetrics holds metrics recorded on events.
+// gauges are collected from current state on each scrape.
+type LiftMetrics struct {
+	Launches         *CounterVec
+	LaunchRejections *CounterVec
+	Shutdowns        *CounterVec
+	Exits            *CounterVec
+	Quarantines      *CounterVec
+	FatalReports     *CounterVec
+	Disconnects      *CounterVec
+	EstablishLatency *HistogramVec
+}
+
+func NewLiftMetrics() *LiftMetrics {
+	return &LiftMetrics{
+		Launches: NewCounterVec(
+			"lift_gs_launches_total",
+			"Number of launched game server processes.",
+			"executable", "process_name",
+		),
+		LaunchRejections: NewCounterVec(
+			"lift_gs_launch_rejections_total",
+			"Number of launches rejected by process limits.",
+			"executable", "process_name", "reason",
+		),
+		Shutdowns: NewCounterVec(
+			"lift_gs_shutdowns_total",
+			"Number of game server shutdowns started by brain.",
+			"executable", "process_name", "reason",
+		),
+		Exits: NewCounterVec(
+			"lift_gs_exits_total",
+			"Number of exited game server processes by class of exit.",
+			"executable", "process_name", "class",
+		),
+		Quarantines: NewCounterVec(
+			"lift_executable_quarantines_total",
+			"Number of executables quarantined by crash loop detection.",
+			"executable",
+		),
+		FatalReports: NewCounterVec(
+			"lift_gs_fatal_reports_total",
+			"Number of fatal errors reported by game servers.",
+			"executable", "process_name",
+		),
+		Disconnects: NewCounterVec(
+			"lift_gs_websocket_disconnects_total",
+			"Number of broken monitoring websocket connections.",
+			"executable", "process_name",
+		),
+		EstablishLatency: NewHistogramVec(
+			"lift_gs_establish_latency_seconds",
+			"Seconds from process start to monitoring websocket established.",
+			EstablishLatencyBuckets,
+			"executable", "process_name",
+		),
+	}
+}
+
+func (m *LiftMetrics) Write(w io.Writer) error {
+	for _, c := range []Collector{
+		m.Launches,
+		m.LaunchRejections,
+		m.Shutdowns,
+		m.Exits,
+		m.Quarantines,
+		m.FatalReports,
+		m.Disconnects,
+		m.EstablishLatency,
+	} {
+		if err := c.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector writes metric families in prometheus text format.
+type Collector interface {
+	Write(w io.Writer) error
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+type vec struct {
+	family
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type CounterVec struct {
+	vec
+}
+
+type GaugeVec struct {
+	vec
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+type HistogramVec struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+func newVec(name string, help string, kind string, labels []string) vec {
+	return vec{
+		family: family{
+			name:   name,
+			help:   help,
+			kind:   kind,
+			labels: labels,
+		},
+		series: make(map[string]*series),
+	}
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, "counter", labels)}
+}
+
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, "gauge", labels)}
+}
+
+// NewHistogramVec creates histogram with upper bounds of buckets in ascending order.
+func NewHistogramVec(
+	name string,
+	help string,
+	buckets []float64,
+	labels ...string,
+) *HistogramVec {
+	return &HistogramVec{
+		family: family{
+			name:   name,
+			help:   help,
+			kind:   "histogram",
+			labels: labels,
+		},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.add(v, labelValues)
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(labelValues).value = v
+}
+
+func (g *GaugeVec) Add(v float64, labelValues ...string) {
+	g.add(v, labelValues)
+}
+
+func (v *vec) add(value float64, labelValues []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).value += value
+}
+
+func (v *vec) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) Write(w io.Writer) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.writeHeader(w); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		if _, err := fmt.Fprintf(
+			w, "%s%s %s\n",
+			v.name, formatLabels(v.labels, s.labelValues, "", ""), formatValue(s.value),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) Write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.writeHeader(w); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, upper := range h.buckets {
+			if _, err := fmt.Fprintf(
+				w, "%s_bucket%s %d\n",
+				h.name, formatLabels(h.labels, s.labelValues, "le", formatValue(upper)), s.counts[i],
+			); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(
+			w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, formatLabels(h.labels, s.labelValues, "le", "+Inf"), s.count,
+			h.name, formatLabels(h.labels, s.labelValues, "", ""), formatValue(s.sum),
+			h.name, formatLabels(h.labels, s.labelValues, "", ""), s.count,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *family) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(
+		w, "# HELP %s %s\n# TYPE %s %s\n",
+		f.name, helpEscaper.Replace(f.help), f.name, f.kind,
+	)
+	return err
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	// quotes are not escaped in help text
+	helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(v)+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, c Collector) string {
+	t.Helper()
+	var b strings.Builder
+	if err := c.Write(&b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return b.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("lift_launches_total", "Processes launched.", "executable", "reason")
+	c.Inc("b", "warm")
+	c.Inc("a", "request")
+	c.Add(2, "a", "request")
+	// counters never decrease
+	c.Add(-1, "a", "request")
+
+	want := `# HELP lift_launches_total Processes launched.
+# TYPE lift_launches_total counter
+lift_launches_total{executable="a",reason="request"} 3
+lift_launches_total{executable="b",reason="warm"} 1
+`
+	if got := write(t, c); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	g := NewGaugeVec("lift_processes", "Processes by state.", "state")
+	g.Set(5, "Ready")
+	g.Add(-2, "Ready")
+	g.Set(0.25, "Allocated")
+
+	want := `# HELP lift_processes Processes by state.
+# TYPE lift_processes gauge
+lift_processes{state="Allocated"} 0.25
+lift_processes{state="Ready"} 3
+`
+	if got := write(t, g); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeWithoutLabels(t *testing.T) {
+	g := NewGaugeVec("lift_up", "Lift is running.")
+	g.Set(1)
+
+	want := `# HELP lift_up Lift is running.
+# TYPE lift_up gauge
+lift_up 1
+`
+	if got := write(t, g); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("lift_start_seconds", "Time to establish.", []float64{0.5, 1, 5}, "executable")
+	h.Observe(0.2, "a")
+	h.Observe(1, "a")
+	h.Observe(10, "a")
+
+	want := `# HELP lift_start_seconds Time to establish.
+# TYPE lift_start_seconds histogram
+lift_start_seconds_bucket{executable="a",le="0.5"} 1
+lift_start_seconds_bucket{executable="a",le="1"} 2
+lift_start_seconds_bucket{executable="a",le="5"} 2
+lift_start_seconds_bucket{executable="a",le="+Inf"} 3
+lift_start_seconds_sum{executable="a"} 11.2
+lift_start_seconds_count{executable="a"} 3
+`
+	if got := write(t, h); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounterVec("lift_errors_total", "Errors.", "message")
+	c.Inc("back\\slash \"quoted\"\nnext line")
+
+	want := `lift_errors_total{message="back\\slash \"quoted\"\nnext line"} 1`
+	if got := write(t, c); !strings.Contains(got, want+"\n") {
+		t.Errorf("got:\n%s\nwant line:\n%s", got, want)
+	}
+}
+
+func TestHelpEscaping(t *testing.T) {
+	g := NewGaugeVec("lift_x", "path C:\\lift \"quoted\"\nsecond line")
+
+	want := "# HELP lift_x path C:\\\\lift \"quoted\"\\nsecond line\n"
+	if got := write(t, g); !strings.HasPrefix(got, want) {
+		t.Errorf("got:\n%s\nwant prefix:\n%s", got, want)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	cases := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, c := range cases {
+		if got := formatValue(c.v); got != c.want {
+			t.Errorf("formatValue(%v) = %s, want %s", c.v, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package procstat
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// USER_HZ which /proc reports cpu times in
+	ClockTicks = 100
+)
+
+var (
+	ErrorUnexpectedFormat = errors.New("unexpected format in /proc")
+)
+
+// CPUTimes are cumulative jiffies of all cpus.
+type CPUTimes struct {
+	Total uint64
+	Idle  uint64
+}
+
+type HostStat struct {
+	Time   time.Time
+	NumCPU int
+
+	Load1  float64
+	Load5  float64
+	Load15 float64
+	// ratio of busy cpu time since previous sample, 0 to 1
+	CPUUsage float64
+	CPUTimes CPUTimes `json:"-"`
+
+	MemTotal     int64
+	MemAvailable int64
+}
+
+// ReadHost samples the host, cpu usage is computed against prev.
+func ReadHost(prev *HostStat) (*HostStat, error) {
+	loadavg, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	stat, err := readFirstLine("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	meminfo, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	return parseHost(string(loadavg), stat, string(meminfo), prev)
+}
+
+// parseHost makes a sample from contents of /proc/loadavg,
+// the first line of /proc/stat and /proc/meminfo.
+func parseHost(loadavg string, stat string, meminfo string, prev *HostStat) (*HostStat, error) {
+	h := &HostStat{
+		Time:   time.Now(),
+		NumCPU: runtime.NumCPU(),
+	}
+
+	if err := parseLoadAvg(h, loadavg); err != nil {
+		return nil, err
+	}
+	if err := parseMemInfo(h, meminfo); err != nil {
+		return nil, err
+	}
+
+	t, err := parseCPUTimes(stat)
+	if err != nil {
+		return nil, err
+	}
+	h.CPUTimes = t
+	// counters may go back when cpus go offline
+	if prev != nil && t.Total > prev.CPUTimes.Total && t.Idle >= prev.CPUTimes.Idle {
+		total := t.Total - prev.CPUTimes.Total
+		idle := t.Idle - prev.CPUTimes.Idle
+		busy := float64(total) - float64(idle)
+		h.CPUUsage = math.Max(0, math.Min(1, busy/float64(total)))
+	}
+	return h, nil
+}
+
+func readFirstLine(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return "", err
+		}
+		return "", ErrorUnexpectedFormat
+	}
+	return s.Text(), nil
+}
+
+func parseLoadAvg(h *HostStat, s string) error {
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return ErrorUnexpectedFormat
+	}
+	loads := make([]float64, 3)
+	for i := range loads {
+		var err error
+		if loads[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return err
+		}
+	}
+	h.Load1, h.Load5, h.Load15 = loads[0], loads[1], loads[2]
+	return nil
+}
+
+func parseCPUTimes(line string) (CPUTimes, error) {
+	// cpu user nice system idle iowait irq softirq steal ...
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return CPUTimes{}, ErrorUnexpectedFormat
+	}
+
+	t := CPUTimes{}
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return CPUTimes{}, err
+		}
+		// guest times are included in user and nice
+		if i >= 8 {
+			break
+		}
+		t.Total += v
+		// idle and iowait
+		if i == 3 || i == 4 {
+			t.Idle += v
+		}
+	}
+	return t, nil
+}
+
+func parseMemInfo(h *HostStat, s string) error {
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		var dst *int64
+		switch fields[0] {
+		case "MemTotal:":
+			dst = &h.MemTotal
+		case "MemAvailable:":
+			dst = &h.MemAvailable
+		default:
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		*dst = kb * 1024
+	}
+	return nil
+}
+
+type ProcessStat struct {
+	Pid     int
+	Time    time.Time
+	CPUTime time.Duration
+	// cores used since previous sample
+	CPUUsage float64
+	RSS      int64
+	FDs      int
+	Threads  int
+}
+
+// ReadProcess samples the process, cpu usage is computed against prev.
+func ReadProcess(pid int, prev *ProcessStat) (*ProcessStat, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	b, err := os.ReadFile(dir + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	fds, err := os.ReadDir(dir + "/fd")
+	if err != nil {
+		return nil, err
+	}
+	return parseProcess(pid, string(b), len(fds), time.Now(), prev)
+}
+
+// parseProcess makes a sample from /proc/pid/stat and the count of fds.
+func parseProcess(pid int, stat string, fds int, now time.Time, prev *ProcessStat) (*ProcessStat, error) {
+	// comm in parentheses may contain spaces
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return nil, ErrorUnexpectedFormat
+	}
+	// fields after comm start from state, the 3rd field
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return nil, ErrorUnexpectedFormat
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := strconv.Atoi(fields[17])
+	if err != nil {
+		return nil, err
+	}
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ProcessStat{
+		Pid:     pid,
+		Time:    now,
+		CPUTime: time.Duration(utime+stime) * time.Second / ClockTicks,
+		RSS:     rss * int64(os.Getpagesize()),
+		FDs:     fds,
+		Threads: threads,
+	}
+	if prev != nil && prev.Pid == pid {
+		elapsed := p.Time.Sub(prev.Time)
+		if elapsed > 0 && p.CPUTime >= prev.CPUTime {
+			p.CPUUsage = float64(p.CPUTime-prev.CPUTime) / float64(elapsed)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,123 @@
+package procstat
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	loadavg = "0.52 0.58 0.59 1/467 12345\n"
+	meminfo = `MemTotal:       16384 kB
+MemFree:         1024 kB
+MemAvailable:    8192 kB
+Buffers:          512 kB
+`
+	// user nice system idle iowait irq softirq steal guest guest_nice
+	cpuLine = "cpu  100 0 50 800 50 0 0 0 30 0"
+	// comm may contain spaces and parentheses
+	processStat = "1234 (my (game) srv) S 1 1234 1234 0 -1 4194304 100 0 0 0 250 50 0 0 20 0 8 0 1000 104857600 2560 18446744073709551615\n"
+)
+
+func TestParseHost(t *testing.T) {
+	h, err := parseHost(loadavg, cpuLine, meminfo, nil)
+	if err != nil {
+		t.Fatalf("parseHost: %v", err)
+	}
+	if h.Load1 != 0.52 || h.Load5 != 0.58 || h.Load15 != 0.59 {
+		t.Errorf("loads: got %v %v %v", h.Load1, h.Load5, h.Load15)
+	}
+	if h.MemTotal != 16384*1024 || h.MemAvailable != 8192*1024 {
+		t.Errorf("memory: got %d %d", h.MemTotal, h.MemAvailable)
+	}
+	// guest times are not counted twice
+	if h.CPUTimes != (CPUTimes{Total: 1000, Idle: 850}) {
+		t.Errorf("cpu times: got %+v", h.CPUTimes)
+	}
+	if h.CPUUsage != 0 {
+		t.Errorf("usage without previous sample: got %v", h.CPUUsage)
+	}
+}
+
+func TestParseHostUsage(t *testing.T) {
+	cases := []struct {
+		name string
+		prev CPUTimes
+		want float64
+	}{
+		{"busy", CPUTimes{Total: 500, Idle: 450}, 0.2},
+		{"idle", CPUTimes{Total: 500, Idle: 350}, 0},
+		{"idle went back", CPUTimes{Total: 500, Idle: 900}, 0},
+		{"total went back", CPUTimes{Total: 2000, Idle: 100}, 0},
+		{"idle over total", CPUTimes{Total: 900, Idle: 0}, 0},
+	}
+	for _, c := range cases {
+		h, err := parseHost(loadavg, cpuLine, meminfo, &HostStat{CPUTimes: c.prev})
+		if err != nil {
+			t.Fatalf("%s: parseHost: %v", c.name, err)
+		}
+		if h.CPUUsage != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, h.CPUUsage, c.want)
+		}
+	}
+}
+
+func TestParseHostErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		loadavg string
+		stat    string
+	}{
+		{"short loadavg", "0.1 0.2", cpuLine},
+		{"not cpu line", loadavg, "cpu0 1 2 3 4"},
+		{"short cpu line", loadavg, "cpu 1 2 3"},
+	}
+	for _, c := range cases {
+		if _, err := parseHost(c.loadavg, c.stat, meminfo, nil); err != ErrorUnexpectedFormat {
+			t.Errorf("%s: got %v, want %v", c.name, err, ErrorUnexpectedFormat)
+		}
+	}
+}
+
+func TestParseProcess(t *testing.T) {
+	now := time.Now()
+	prev := &ProcessStat{Pid: 1234, Time: now.Add(-time.Second * 4), CPUTime: time.Second}
+
+	p, err := parseProcess(1234, processStat, 12, now, prev)
+	if err != nil {
+		t.Fatalf("parseProcess: %v", err)
+	}
+	if p.CPUTime != time.Second*3 {
+		t.Errorf("cpu time: got %v", p.CPUTime)
+	}
+	if p.CPUUsage != 0.5 {
+		t.Errorf("usage: got %v", p.CPUUsage)
+	}
+	if p.Threads != 8 || p.FDs != 12 || p.RSS != 2560*int64(os.Getpagesize()) {
+		t.Errorf("unexpected stat: %+v", p)
+	}
+
+	// a reused pid is not compared with the previous process
+	p, err = parseProcess(1234, processStat, 12, now, &ProcessStat{Pid: 99, Time: prev.Time})
+	if err != nil {
+		t.Fatalf("parseProcess: %v", err)
+	}
+	if p.CPUUsage != 0 {
+		t.Errorf("usage against other pid: got %v", p.CPUUsage)
+	}
+}
+
+func TestParseProcessErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		stat string
+	}{
+		{"no comm", "1234 game S 1"},
+		{"short", "1234 (game) S 1 1234 1234 0"},
+	}
+	for _, c := range cases {
+		if _, err := parseProcess(1234, c.stat, 0, time.Now(), nil); err != ErrorUnexpectedFormat {
+			t.Errorf("%s: got %v, want %v", c.name, err, ErrorUnexpectedFormat)
+		}
+	}
+}
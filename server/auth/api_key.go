@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"lift/setting"
+	"net/http"
+)
+
+type apiKey struct {
+	key  []byte
+	role Role
+}
+
+// APIKeyAuthenticator authenticates static keys in X-Api-Key header.
+type APIKeyAuthenticator struct {
+	keys []apiKey
+}
+
+func NewAPIKeyAuthenticator(keys []setting.APIKey) (*APIKeyAuthenticator, error) {
+	a := &APIKeyAuthenticator{
+		keys: make([]apiKey, 0, len(keys)),
+	}
+	for _, k := range keys {
+		if k.Key == "" {
+			return nil, errors.New("empty api key")
+		}
+		role, err := ParseRole(k.Role)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = append(a.keys, apiKey{
+			key:  []byte(k.Key),
+			role: role,
+		})
+	}
+	return a, nil
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (Role, error) {
+	given := r.Header.Get(HeaderAPIKey)
+	if given == "" {
+		return "", ErrorNoCredential
+	}
+
+	// compare with all keys so that timing does not tell which matched
+	var role Role
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(k.key, []byte(given)) == 1 {
+			role = k.role
+		}
+	}
+	if role == "" {
+		return "", ErrorInvalidCredential
+	}
+	return role, nil
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"lift/setting"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleMatchmaker Role = "matchmaker"
+	RoleOperator   Role = "operator"
+	RoleGameServer Role = "gameserver"
+)
+
+const (
+	HeaderAPIKey   = "X-Api-Key"
+	ContextKeyRole = "role"
+)
+
+var (
+	ErrorNoCredential      = errors.New("no credential")
+	ErrorInvalidCredential = errors.New("invalid credential")
+	ErrorUnknownRole       = errors.New("unknown role")
+	ErrorUnknownAlgorithm  = errors.New("unknown jwt algorithm")
+)
+
+// Authenticator resolves the role of the credential in request.
+// ErrorNoCredential is returned when the request has no credential for it.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Role, error)
+}
+
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case RoleMatchmaker, RoleOperator, RoleGameServer:
+		return r, nil
+	default:
+		return "", ErrorUnknownRole
+	}
+}
+
+// Chain tries authenticators in order until one finds a credential.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Role, error) {
+	for _, a := range c {
+		role, err := a.Authenticate(r)
+		if err == ErrorNoCredential {
+			continue
+		}
+		return role, err
+	}
+	return "", ErrorNoCredential
+}
+
+// NewAuthenticator builds authenticator from setting,
+// nil is returned when no authenticator is configured.
+func NewAuthenticator(s setting.AuthSetting) (Authenticator, error) {
+	chain := Chain{}
+
+	if len(s.APIKeys) > 0 {
+		a, err := NewAPIKeyAuthenticator(s.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+
+	if s.JWT != nil {
+		var key []byte
+		switch s.JWT.Algorithm {
+		case AlgorithmHS256:
+			key = []byte(s.JWT.Secret)
+		case AlgorithmRS256:
+			b, err := os.ReadFile(s.JWT.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			key = b
+		}
+
+		a, err := NewJWTAuthenticator(s.JWT.Algorithm, key, s.JWT.RoleClaim)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(h, "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	return token, true
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/http"
+
+	libjwt "github.com/golang-jwt/jwt"
+)
+
+const (
+	AlgorithmHS256   = "HS256"
+	AlgorithmRS256   = "RS256"
+	DefaultRoleClaim = "role"
+)
+
+// JWTAuthenticator authenticates bearer token in Authorization header.
+// the role is read from roleClaim of the token.
+type JWTAuthenticator struct {
+	method    libjwt.SigningMethod
+	key       interface{}
+	roleClaim string
+}
+
+// NewJWTAuthenticator takes shared secret for HS256
+// or PEM encoded public key for RS256.
+func NewJWTAuthenticator(
+	algorithm string,
+	key []byte,
+	roleClaim string,
+) (*JWTAuthenticator, error) {
+	if roleClaim == "" {
+		roleClaim = DefaultRoleClaim
+	}
+
+	a := &JWTAuthenticator{
+		roleClaim: roleClaim,
+	}
+	switch algorithm {
+	case AlgorithmHS256:
+		if len(key) == 0 {
+			return nil, ErrorInvalidCredential
+		}
+		a.method = libjwt.SigningMethodHS256
+		a.key = key
+	case AlgorithmRS256:
+		pub, err := libjwt.ParseRSAPublicKeyFromPEM(key)
+		if err != nil {
+			return nil, err
+		}
+		a.method = libjwt.SigningMethodRS256
+		a.key = pub
+	default:
+		return nil, ErrorUnknownAlgorithm
+	}
+	return a, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (Role, error) {
+	tokenString, ok := bearerToken(r)
+	if !ok {
+		return "", ErrorNoCredential
+	}
+
+	claims := libjwt.MapClaims{}
+	token, err := libjwt.ParseWithClaims(
+		tokenString,
+		claims,
+		func(t *libjwt.Token) (interface{}, error) {
+			if t.Method.Alg() != a.method.Alg() {
+				return nil, ErrorInvalidCredential
+			}
+			return a.key, nil
+		},
+	)
+	if err != nil || !token.Valid {
+		return "", ErrorInvalidCredential
+	}
+
+	s, ok := claims[a.roleClaim].(string)
+	if !ok {
+		return "", ErrorInvalidCredential
+	}
+	role, err := ParseRole(s)
+	if err != nil {
+		return "", ErrorInvalidCredential
+	}
+	return role, nil
+}
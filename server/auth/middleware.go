@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"lift/gsmap/monitor"
+	"lift/server/errres"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware allows requests whose credential has one of roles.
+// nil authenticator allows every request.
+func Middleware(a Authenticator, roles ...Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if a == nil {
+			return next
+		}
+
+		return func(c echo.Context) error {
+			role, err := a.Authenticate(c.Request())
+			if err != nil {
+				return errres.Unauthorized(err, c.Logger())
+			}
+
+			for _, r := range roles {
+				if r == role {
+					c.Set(ContextKeyRole, role)
+					return next(c)
+				}
+			}
+			return errres.Forbidden(c.Logger())
+		}
+	}
+}
+
+type SecretVerifier interface {
+	VerifySecret(id string, secret string) bool
+}
+
+// GameServerMiddleware allows game servers which present
+// the secret minted for the launch of process id in path.
+func GameServerMiddleware(v SecretVerifier, idParam string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			secret := c.Request().Header.Get(monitor.HeaderSecret)
+			if secret == "" {
+				return errres.Unauthorized(ErrorNoCredential, c.Logger())
+			}
+			if !v.VerifySecret(c.Param(idParam), secret) {
+				return errres.Unauthorized(ErrorInvalidCredential, c.Logger())
+			}
+
+			c.Set(ContextKeyRole, RoleGameServer)
+			return next(c)
+		}
+	}
+}
@@ -7,23 +7,30 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+type Reloader interface {
+	Reload() (*brain.ReloadResult, error)
+}
+
 type Components struct {
 	metadata   *Metadata
 	wsUpgrader *websocket.Upgrader
 	gsMap      *gsmap.GSMap
 	brain      *brain.Brain
+	reloader   Reloader
 }
 
 func NewComponents(
 	m *Metadata,
 	gsm *gsmap.GSMap,
 	b *brain.Brain,
+	r Reloader,
 ) *Components {
 	return &Components{
 		metadata:   m,
 		wsUpgrader: &websocket.Upgrader{},
 		gsMap:      gsm,
 		brain:      b,
+		reloader:   r,
 	}
 }
 
@@ -42,3 +49,7 @@ func (c *Components) GSMap() *gsmap.GSMap {
 func (c *Components) Brain() *brain.Brain {
 	return c.brain
 }
+
+func (c *Components) Reloader() Reloader {
+	return c.reloader
+}
@@ -21,3 +21,23 @@ func NotInService(l logger.Logger) error {
 	l.Error("not in service")
 	return echo.NewHTTPError(http.StatusInternalServerError, "not in service")
 }
+
+func Timeout(err error, l logger.Logger) error {
+	l.Warn(err)
+	return echo.NewHTTPError(http.StatusGatewayTimeout, "timed out")
+}
+
+func Unavailable(err error, l logger.Logger) error {
+	l.Warn(err)
+	return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+}
+
+func Unauthorized(err error, l logger.Logger) error {
+	l.Warn(err)
+	return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+}
+
+func Forbidden(l logger.Logger) error {
+	l.Warn("forbidden")
+	return echo.NewHTTPError(http.StatusForbidden, "forbidden")
+}
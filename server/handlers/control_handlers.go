@@ -1,10 +1,18 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"lift/brain"
+	"lift/brain/portman"
+	"lift/gsmap"
+	"lift/gsmap/gs"
 	"lift/gsmap/gsinfo"
+	"lift/gsmap/monitor"
 	"lift/server/context"
 	"lift/server/errres"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -38,16 +46,182 @@ func ControlGSInfo(c echo.Context) error {
 	return c.JSON(http.StatusOK, info)
 }
 
+type PortInfoResponse struct {
+	portman.PortInfo
+	Allocations []portman.Allocation
+}
+
 func ControlPortInfo(c echo.Context) error {
 	ctx, err := context.FromEchoContext(c)
 	if err != nil {
 		return errres.ServerError(err, c.Logger())
 	}
 
-	info, err := ctx.Brain().PortMan().Info()
+	pm := ctx.Brain().PortMan()
+	allocations, err := pm.Allocations(c.QueryParam("state"))
+	if err == portman.ErrorInvalidStatus {
+		return errres.BadRequest(err, c.Logger())
+	} else if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	info, err := pm.Info()
 	if err != nil {
 		return errres.ServerError(err, c.Logger())
 	}
 
-	return c.JSON(http.StatusOK, info)
+	return c.JSON(http.StatusOK, PortInfoResponse{
+		PortInfo:    info,
+		Allocations: allocations,
+	})
+}
+
+type ResourcesResponse struct {
+	// null when sampling is disabled or not done yet
+	Resources *brain.Resources
+}
+
+func ControlResources(c echo.Context) error {
+	ctx, err := context.FromEchoContext(c)
+	if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	return c.JSON(http.StatusOK, ResourcesResponse{
+		Resources: ctx.Brain().Resources(),
+	})
+}
+
+type ExitsParam struct {
+	Executable string `query:"executable"`
+	Class      string `query:"class" validate:"omitempty,oneof=clean stopped crashed oom unknown"`
+	Limit      int    `query:"limit" validate:"min=0"`
+}
+
+type ExitsResponse struct {
+	// newest first
+	Exits []gsinfo.ExitInfo
+}
+
+func ControlExits(c echo.Context) error {
+	param := ExitsParam{}
+	if err := c.Bind(&param); err != nil {
+		return errres.BadRequest(err, c.Logger())
+	}
+	if err := c.Validate(&param); err != nil {
+		return errres.BadRequest(err, c.Logger())
+	}
+
+	ctx, err := context.FromEchoContext(c)
+	if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	return c.JSON(http.StatusOK, ExitsResponse{
+		Exits: ctx.Brain().Exits(param.Executable, param.Class, param.Limit),
+	})
+}
+
+type HealthResponse struct {
+	Executables []brain.ExecutableHealth
+}
+
+func ControlHealth(c echo.Context) error {
+	ctx, err := context.FromEchoContext(c)
+	if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	return c.JSON(http.StatusOK, HealthResponse{
+		Executables: ctx.Brain().Health(),
+	})
+}
+
+func ControlResetHealth(c echo.Context) error {
+	ctx, err := context.FromEchoContext(c)
+	if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	h, err := ctx.Brain().ResetHealth(c.Param("executable"))
+	if err == brain.ErrorIndexOutOfRange || err == brain.ErrorExecutableNotFound {
+		return errres.BadRequest(err, c.Logger())
+	} else if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	return c.JSON(http.StatusOK, h)
+}
+
+type CommandParam struct {
+	ProcessId  string          `param:"id" validate:"required,uuid4,min=36,max=36"`
+	Command    string          `json:"Command" validate:"required,oneof=reserve_slot set_max_players broadcast_notice drain custom"`
+	Payload    json.RawMessage `json:"Payload"`
+	TimeoutSec int             `json:"TimeoutSec" validate:"min=0,max=300"`
+}
+
+type CommandResponse struct {
+	RequestId    string
+	Reply        string
+	ReplyPayload json.RawMessage
+}
+
+func ControlCommand(c echo.Context) error {
+	param := CommandParam{}
+	if err := c.Bind(&param); err != nil {
+		return errres.BadRequest(err, c.Logger())
+	}
+	if err := c.Validate(&param); err != nil {
+		return errres.BadRequest(err, c.Logger())
+	}
+
+	code, ok := monitor.CommandCodeFromName(param.Command)
+	if !ok {
+		return errres.BadRequest(errors.New("unknown command"), c.Logger())
+	}
+
+	ctx, err := context.FromEchoContext(c)
+	if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	reply, err := ctx.Brain().SendCommand(
+		param.ProcessId,
+		code,
+		param.Payload,
+		time.Second*time.Duration(param.TimeoutSec),
+	)
+	if err == gsmap.ErrorNoSuchItem || err == gs.ErrorNotEstablished {
+		return errres.BadRequest(err, c.Logger())
+	} else if err == gs.ErrorCommandTimeout {
+		return errres.Timeout(err, c.Logger())
+	} else if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	return c.JSON(http.StatusOK, CommandResponse{
+		RequestId:    reply.RequestId,
+		Reply:        monitor.ReplyCodeName(reply.ReplyCode),
+		ReplyPayload: reply.ReplyPayload,
+	})
+}
+
+type ReloadResponse struct {
+	Ignored []string
+}
+
+func ControlReload(c echo.Context) error {
+	ctx, err := context.FromEchoContext(c)
+	if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	result, err := ctx.Reloader().Reload()
+	if err != nil {
+		return errres.BadRequest(err, c.Logger())
+	}
+
+	return c.JSON(http.StatusOK, ReloadResponse{
+		Ignored: result.Ignored,
+	})
 }
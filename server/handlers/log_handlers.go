@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"lift/gsmap/gsinfo"
+	"lift/server/context"
+	"lift/server/errres"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// comment sent to keep idle streams open through proxies
+	LogKeepAliveInterval = time.Second * 15
+)
+
+type LogsParam struct {
+	ProcessId string `param:"id" validate:"required,uuid4,min=36,max=36"`
+	Stream    string `query:"stream" validate:"omitempty,oneof=stdout stderr"`
+	// last lines returned, zero means all buffered lines
+	Limit int `query:"limit" validate:"min=0"`
+}
+
+type LogsResponse struct {
+	// oldest first
+	Lines []gsinfo.LogLine
+}
+
+func filterLines(lines []gsinfo.LogLine, stream string, limit int) []gsinfo.LogLine {
+	filtered := make([]gsinfo.LogLine, 0, len(lines))
+	for _, l := range lines {
+		if stream == "" || l.Stream == stream {
+			filtered = append(filtered, l)
+		}
+	}
+	if limit > 0 && len(filtered) > limit {
+		filtered = filtered[len(filtered)-limit:]
+	}
+	return filtered
+}
+
+func bindLogsParam(c echo.Context) (*LogsParam, error) {
+	param := &LogsParam{}
+	if err := c.Bind(param); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(param); err != nil {
+		return nil, err
+	}
+	return param, nil
+}
+
+func ControlLogs(c echo.Context) error {
+	param, err := bindLogsParam(c)
+	if err != nil {
+		return errres.BadRequest(err, c.Logger())
+	}
+
+	ctx, err := context.FromEchoContext(c)
+	if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	gs, err := ctx.GSMap().Item(param.ProcessId)
+	if err != nil {
+		return errres.BadRequest(err, c.Logger())
+	}
+
+	return c.JSON(http.StatusOK, LogsResponse{
+		Lines: filterLines(gs.Logs(), param.Stream, param.Limit),
+	})
+}
+
+// ControlFollowLogs streams buffered lines then new lines as server-sent events
+// until the process exits, which is sent as exit event with the exit record.
+func ControlFollowLogs(c echo.Context) error {
+	param, err := bindLogsParam(c)
+	if err != nil {
+		return errres.BadRequest(err, c.Logger())
+	}
+
+	ctx, err := context.FromEchoContext(c)
+	if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	gs, err := ctx.GSMap().Item(param.ProcessId)
+	if err != nil {
+		return errres.BadRequest(err, c.Logger())
+	}
+
+	lines, ch, cancel := gs.FollowLogs()
+	defer cancel()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	for _, l := range filterLines(lines, param.Stream, param.Limit) {
+		if err := writeEvent(res, "line", l); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+
+	keepAlive := time.NewTicker(LogKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case l, ok := <-ch:
+			if !ok {
+				if exit := gs.Exit(); exit != nil {
+					writeEvent(res, "exit", exit)
+					res.Flush()
+				}
+				return nil
+			}
+			if param.Stream != "" && l.Stream != param.Stream {
+				continue
+			}
+			if err := writeEvent(res, "line", l); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+func writeEvent(res *echo.Response, event string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"lift/server/context"
+	"lift/server/errres"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+func Metrics(c echo.Context) error {
+	ctx, err := context.FromEchoContext(c)
+	if err != nil {
+		return errres.ServerError(err, c.Logger())
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, MetricsContentType)
+	c.Response().WriteHeader(http.StatusOK)
+	if err = ctx.Brain().WriteMetrics(c.Response()); err != nil {
+		c.Logger().Error(err)
+	}
+	return nil
+}
@@ -34,7 +34,7 @@ func ProcessConnect(c echo.Context) error {
 		return errres.BadRequest(err, c.Logger())
 	}
 
-	if gs.Established() {
+	if !gs.ClaimConnection() {
 		return errres.BadRequest(ErrorDuplicatedConnection, c.Logger())
 	}
 
@@ -44,6 +44,7 @@ func ProcessConnect(c echo.Context) error {
 		nil,
 	)
 	if err != nil {
+		gs.ReleaseConnection()
 		return errres.ServerError(err, c.Logger())
 	}
 
@@ -5,8 +5,10 @@ import (
 	"lift/gsmap/gsinfo"
 	"lift/server/context"
 	"lift/server/errres"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -29,26 +31,41 @@ func Root(c echo.Context) error {
 	})
 }
 
+const (
+	DefaultRetryAfterSec = 5
+)
+
 type NextPortResponse struct {
 	GsPort gsinfo.GSPort
 }
 
 func NextPort(c echo.Context) error {
-	idxStr := c.Param("index")
-	idx, err := strconv.ParseInt(idxStr, 10, 64)
-	if err != nil {
-		return errres.BadRequest(err, c.Logger())
-	}
-
 	ctx, err := context.FromEchoContext(c)
 	if err != nil {
 		return errres.ServerError(err, c.Logger())
 	}
 	b := ctx.Brain()
 
-	p, err := b.Launch(int(idx))
-	if err == brain.ErrorIndexOutOfRange {
+	key := c.Param("executable")
+	// queued launch gives up when the client is gone
+	p, err := b.Launch(c.Request().Context(), key)
+	if err == brain.ErrorIndexOutOfRange || err == brain.ErrorExecutableNotFound {
 		return errres.BadRequest(err, c.Logger())
+	} else if err == brain.ErrorCapacityReached ||
+		err == brain.ErrorInsufficientResources ||
+		err == brain.ErrorLaunchQueueFull {
+		setRetryAfter(c, b.Params().RetryAfter)
+		return errres.Unavailable(err, c.Logger())
+	} else if err == brain.ErrorExecutableUnhealthy {
+		// retrying before the next probe is rejected again
+		retryAfter := b.UntilProbe(key)
+		if retryAfter <= 0 {
+			retryAfter = b.Params().RetryAfter
+		}
+		setRetryAfter(c, retryAfter)
+		return errres.Unavailable(err, c.Logger())
+	} else if err == brain.ErrorLaunchCanceled {
+		return errres.Unavailable(err, c.Logger())
 	} else if err != nil {
 		return errres.ServerError(err, c.Logger())
 	}
@@ -56,25 +73,29 @@ func NextPort(c echo.Context) error {
 	return c.JSON(http.StatusOK, NextPortResponse{GsPort: *p})
 }
 
+// setRetryAfter sets Retry-After in whole seconds rounded up,
+// DefaultRetryAfterSec when d is not positive.
+func setRetryAfter(c echo.Context, d time.Duration) {
+	sec := int(math.Ceil(d.Seconds()))
+	if sec <= 0 {
+		sec = DefaultRetryAfterSec
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(sec))
+}
+
 type BackfillPortResponse struct {
 	List []gsinfo.GSBackfillPort
 }
 
 func BackfillPort(c echo.Context) error {
-	idxStr := c.Param("index")
-	idx, err := strconv.ParseInt(idxStr, 10, 64)
-	if err != nil {
-		return errres.BadRequest(err, c.Logger())
-	}
-
 	ctx, err := context.FromEchoContext(c)
 	if err != nil {
 		return errres.ServerError(err, c.Logger())
 	}
 	b := ctx.Brain()
 
-	backfillList, err := b.BackfillList(int(idx))
-	if err == brain.ErrorIndexOutOfRange {
+	backfillList, err := b.BackfillList(c.Param("executable"))
+	if err == brain.ErrorIndexOutOfRange || err == brain.ErrorExecutableNotFound {
 		return errres.BadRequest(err, c.Logger())
 	} else if err != nil {
 		return errres.ServerError(err, c.Logger())
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"lift/server/auth"
 	"lift/server/context"
 	"lift/server/handlers"
 	"lift/server/validator"
@@ -11,14 +12,21 @@ import (
 )
 
 type ServerParams struct {
-	listenAt string
-	logLevel log.Lvl
+	listenAt      string
+	logLevel      log.Lvl
+	authenticator auth.Authenticator
 }
 
-func NewServerParams(listenAt string, logLevel log.Lvl) *ServerParams {
+// nil authenticator disables authentication except for game servers.
+func NewServerParams(
+	listenAt string,
+	logLevel log.Lvl,
+	authenticator auth.Authenticator,
+) *ServerParams {
 	return &ServerParams{
-		listenAt: listenAt,
-		logLevel: logLevel,
+		listenAt:      listenAt,
+		logLevel:      logLevel,
+		authenticator: authenticator,
 	}
 }
 
@@ -55,15 +63,37 @@ func (s *Server) Run() <-chan error {
 	s.echo.Use(middleware.Recover())
 	s.echo.Use(middleware.Logger())
 
+	a := s.params.authenticator
+	if a == nil {
+		s.echo.Logger.Warn("authentication is disabled")
+	}
+
 	s.echo.GET("/", handlers.Root)
-	s.echo.GET("/nextport/:index", handlers.NextPort)
-	s.echo.GET("/backfillport/:index", handlers.BackfillPort)
 
-	s.echo.GET("/process/connect/:id", handlers.ProcessConnect)
+	// per route, a group without prefix would answer unknown paths with 401
+	matchmaker := auth.Middleware(a, auth.RoleMatchmaker, auth.RoleOperator)
+	s.echo.GET("/nextport/:executable", handlers.NextPort, matchmaker)
+	s.echo.GET("/backfillport/:executable", handlers.BackfillPort, matchmaker)
+
+	process := s.echo.Group("/process")
+	process.GET("/connect/:id", handlers.ProcessConnect,
+		auth.GameServerMiddleware(s.components.Brain(), "id"),
+	)
+
+	s.echo.GET("/metrics", handlers.Metrics, auth.Middleware(a, auth.RoleOperator))
 
-	s.echo.GET("/control", handlers.ControlIndex)
-	s.echo.GET("/control/gsinfo", handlers.ControlGSInfo)
-	s.echo.GET("/control/portinfo", handlers.ControlPortInfo)
+	control := s.echo.Group("/control", auth.Middleware(a, auth.RoleOperator))
+	control.GET("", handlers.ControlIndex)
+	control.GET("/gsinfo", handlers.ControlGSInfo)
+	control.GET("/portinfo", handlers.ControlPortInfo)
+	control.GET("/resources", handlers.ControlResources)
+	control.GET("/exits", handlers.ControlExits)
+	control.GET("/health", handlers.ControlHealth)
+	control.POST("/health/:executable/reset", handlers.ControlResetHealth)
+	control.GET("/gs/:id/logs", handlers.ControlLogs)
+	control.GET("/gs/:id/logs/follow", handlers.ControlFollowLogs)
+	control.POST("/command/:id", handlers.ControlCommand)
+	control.POST("/reload", handlers.ControlReload)
 
 	s.echo.Logger.SetLevel(s.params.logLevel)
 	go s.start()
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"lift/brain"
+	"lift/setting"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// reloader reloads setting file on SIGHUP or admin request.
+type reloader struct {
+	fileName string
+	current  *setting.Setting
+	brain    *brain.Brain
+	logger   echo.Logger
+	mu       sync.Mutex
+}
+
+func newReloader(
+	fileName string,
+	current *setting.Setting,
+	b *brain.Brain,
+	logger echo.Logger,
+) *reloader {
+	return &reloader{
+		fileName: fileName,
+		current:  current,
+		brain:    b,
+		logger:   logger,
+	}
+}
+
+func (r *reloader) Reload() (*brain.ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := loadSetting(r.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.brain.Reload(newBrainParams(next))
+	if err != nil {
+		return nil, err
+	}
+
+	// server side settings are bound when server started
+	if next.ServiceName != r.current.ServiceName ||
+		next.ServiceVersion != r.current.ServiceVersion {
+		result.Ignored = append(result.Ignored, "ServiceName", "ServiceVersion")
+	}
+	if next.ServiceListenAt != r.current.ServiceListenAt {
+		result.Ignored = append(result.Ignored, "ServiceListenAt")
+	}
+	if !reflect.DeepEqual(next.Auth, r.current.Auth) {
+		result.Ignored = append(result.Ignored, "Auth")
+	}
+
+	r.logger.SetLevel(log.Lvl(next.LogLevel))
+	r.current = next
+	r.logger.Infof("setting reloaded from %s", r.fileName)
+	return result, nil
+}
+
+func (r *reloader) listenSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	for range ch {
+		result, err := r.Reload()
+		if err != nil {
+			r.logger.Errorf("%s: failed to reload setting, keep running with current setting", err.Error())
+			continue
+		}
+		if len(result.Ignored) > 0 {
+			r.logger.Warnf("changes need restart to be applied: %v", result.Ignored)
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"lift/brain"
 	"lift/brain/portman"
 	"lift/gsmap"
+	"lift/gsmap/gslog"
 	"lift/server"
+	"lift/server/auth"
 	"lift/server/context"
 	"lift/setting"
 	"os"
@@ -40,9 +42,79 @@ func loadSetting(file string) (*setting.Setting, error) {
 		return nil, err
 	}
 
+	s.SetDefaults()
+	if err = s.Validate(); err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
+func portRanges(ranges []setting.PortRange) []portman.Range {
+	rs := make([]portman.Range, 0, len(ranges))
+	for _, r := range ranges {
+		rs = append(rs, portman.Range{From: r.From, To: r.To})
+	}
+	return rs
+}
+
+// portPools returns dedicated ranges of executables by their names.
+func portPools(exes []setting.GSExecutable) map[string][]portman.Range {
+	pools := make(map[string][]portman.Range)
+	for _, exe := range exes {
+		if len(exe.PortRanges) > 0 {
+			pools[exe.Name] = portRanges(exe.PortRanges)
+		}
+	}
+	return pools
+}
+
+func newBrainParams(setting *setting.Setting) *brain.BrainParams {
+	// validated on loading
+	callbackURL, _ := setting.CallbackURL()
+	return &brain.BrainParams{
+		GSExecutables:    setting.GSExecutables,
+		GSListenAddress:  setting.GSListenAddress,
+		GSMessageTimeout: time.Second * time.Duration(setting.GSMessageTimeoutSec),
+		CallbackURL:      callbackURL,
+		PortParams: portman.PortManParams{
+			Ranges:             portRanges(setting.SharedPortRanges()),
+			Pools:              portPools(setting.GSExecutables),
+			Exclude:            setting.PortExclude,
+			ProbeTCP:           setting.PortProbeTCP,
+			ProbeUDP:           setting.PortProbeUDP,
+			ProbeAddress:       setting.GSListenAddress,
+			QuarantineDuration: time.Second * time.Duration(setting.PortQuarantineSec),
+			CooldownDuration:   time.Second * time.Duration(setting.PortCooldownSec),
+		},
+		LoopInterval:        time.Second * time.Duration(setting.BrainIntervalSec),
+		MinimumWaitForClose: time.Second * time.Duration(setting.BrainMinimumWaitSec),
+		JournalFile:         setting.StateJournalFile,
+		RestoreGrace:        time.Second * time.Duration(setting.RestoreGraceSec),
+		MaxProcesses:        setting.MaxProcesses,
+		MaxStarting:         setting.MaxStarting,
+		RetryAfter:          time.Second * time.Duration(setting.LaunchRetryAfterSec),
+
+		ResourceSampleInterval: time.Second * time.Duration(setting.ResourceSampleSec),
+		HostMaxCPUPercent:      setting.HostMaxCPUPercent,
+		HostMaxMemoryPercent:   setting.HostMaxMemoryPercent,
+		CgroupParent:           setting.CgroupParent,
+		ExitHistorySize:        setting.ExitHistorySize,
+		GSLog: gslog.Params{
+			Dir:        setting.GSLogDir,
+			MaxSize:    setting.GSLogMaxSizeMB * 1024 * 1024,
+			MaxAge:     time.Second * time.Duration(setting.GSLogMaxAgeSec),
+			MaxBackups: setting.GSLogMaxBackups,
+			Retention:  time.Second * time.Duration(setting.GSLogRetentionSec),
+		},
+		CrashLoop: brain.CrashLoopParams{
+			Threshold:  setting.CrashLoopThreshold,
+			Window:     time.Second * time.Duration(setting.CrashLoopWindowSec),
+			Backoff:    time.Second * time.Duration(setting.CrashLoopBackoffSec),
+			MaxBackoff: time.Second * time.Duration(setting.CrashLoopMaxBackoffSec),
+		},
+	}
+}
+
 func Run() {
 	e := echo.New()
 	fileName := parseFlags()
@@ -50,36 +122,39 @@ func Run() {
 	if err != nil {
 		e.Logger.Fatal(err)
 	}
+	// brain logs while restoring before server starts
+	e.Logger.SetLevel(log.Lvl(setting.LogLevel))
 
 	gsm := gsmap.NewGSMap(e.Logger)
-	b, err := brain.NewBrain(
-		&brain.BrainParams{
-			GSExecutables:    setting.GSExecutables,
-			GSListenAddress:  setting.GSListenAddress,
-			GSMessageTimeout: time.Second * time.Duration(setting.GSMessageTimeoutSec),
-			PortParams: portman.PortManParams{
-				InitialCapacity: setting.PortCapacity,
-				StartFrom:       setting.PortStartFrom,
-			},
-			LoopInterval:        time.Second * time.Duration(setting.BrainIntervalSec),
-			MinimumWaitForClose: time.Second * time.Duration(setting.BrainMinimumWaitSec),
-		},
-		gsm,
-		e.Logger,
-	)
+	b, err := brain.NewBrain(newBrainParams(setting), gsm, e.Logger)
 	if err != nil {
 		e.Logger.Fatal(err)
 	}
 
+	a, err := auth.NewAuthenticator(setting.Auth)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	r := newReloader(fileName, setting, b, e.Logger)
+	go r.listenSignal()
+
 	s := server.NewServer(e,
 		context.NewComponents(
 			context.NewMetadata(setting.ServiceName, setting.ServiceVersion),
 			gsm,
 			b,
+			r,
+		),
+		server.NewServerParams(
+			setting.ServiceListenAt,
+			log.Lvl(setting.LogLevel),
+			a,
 		),
-		server.NewServerParams(setting.ServiceListenAt, log.Lvl(setting.LogLevel)),
 	)
 	errCh := s.Run()
 
-	e.Logger.Fatal(<-errCh)
+	err = <-errCh
+	b.Close()
+	e.Logger.Fatal(err)
 }
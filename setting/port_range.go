@@ -0,0 +1,103 @@
+package setting
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	ErrorInvalidPortRange = errors.New("port range should be From <= To and From should be positive")
+	ErrorPortOverflow     = errors.New("PortStartFrom + PortCapacity exceeds 65535")
+	ErrorPortOverlap      = errors.New("port ranges overlap")
+	ErrorNoPortInRange    = errors.New("no port left in ranges after exclusion")
+)
+
+// PortRange includes both From and To.
+type PortRange struct {
+	From uint16
+	To   uint16
+}
+
+func (r PortRange) overlaps(o PortRange) bool {
+	return r.From <= o.To && o.From <= r.To
+}
+
+// SharedPortRanges returns PortRanges,
+// or a range of PortStartFrom and PortCapacity when it is empty.
+func (s *Setting) SharedPortRanges() []PortRange {
+	if len(s.PortRanges) > 0 {
+		return s.PortRanges
+	}
+	if s.PortCapacity <= 0 {
+		return nil
+	}
+	return []PortRange{{
+		From: s.PortStartFrom,
+		To:   uint16(int64(s.PortStartFrom) + s.PortCapacity - 1),
+	}}
+}
+
+func (s *Setting) validatePorts() error {
+	pools := [][]PortRange{}
+	shared := false
+	for _, exe := range s.GSExecutables {
+		if len(exe.PortRanges) > 0 {
+			pools = append(pools, exe.PortRanges)
+		} else {
+			shared = true
+		}
+	}
+
+	// shared ranges can be empty when every executable has its own
+	legacy := s.PortCapacity != 0 || s.PortStartFrom != 0
+	if len(s.PortRanges) == 0 && (shared || legacy) {
+		if s.PortCapacity <= 0 || s.PortStartFrom == 0 {
+			return ErrorInvalidPort
+		}
+		if int64(s.PortStartFrom)+s.PortCapacity-1 > math.MaxUint16 {
+			return ErrorPortOverflow
+		}
+	}
+	ranges := append([]PortRange{}, s.SharedPortRanges()...)
+	for _, pool := range pools {
+		ranges = append(ranges, pool...)
+	}
+	if shared {
+		pools = append(pools, s.SharedPortRanges())
+	}
+
+	for i, r := range ranges {
+		if r.From == 0 || r.From > r.To {
+			return ErrorInvalidPortRange
+		}
+		for _, o := range ranges[:i] {
+			if r.overlaps(o) {
+				return ErrorPortOverlap
+			}
+		}
+	}
+
+	for _, pool := range pools {
+		if s.countPorts(pool) == 0 {
+			return ErrorNoPortInRange
+		}
+	}
+	return nil
+}
+
+func (s *Setting) countPorts(ranges []PortRange) int {
+	excluded := make(map[uint16]bool, len(s.PortExclude))
+	for _, n := range s.PortExclude {
+		excluded[n] = true
+	}
+
+	count := 0
+	for _, r := range ranges {
+		for n := int(r.From); n <= int(r.To); n++ {
+			if !excluded[uint16(n)] {
+				count++
+			}
+		}
+	}
+	return count
+}
@@ -1,9 +1,154 @@
 package setting
 
+import (
+	"errors"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorNoExecutable      = errors.New("no GSExecutables")
+	ErrorEmptyProcessName  = errors.New("empty ProcessName")
+	ErrorEmptyName         = errors.New("empty Name")
+	ErrorNumericName       = errors.New("Name should not be a number, it is ambiguous with index")
+	ErrorInvalidName       = errors.New("Name should contain only letters, digits, '.', '_' and '-', and not be '.' or '..'")
+	ErrorDuplicateName     = errors.New("duplicate Name in GSExecutables")
+	ErrorInvalidVar        = errors.New("Vars name should not be empty or contain braces")
+	ErrorInvalidEnv        = errors.New("Env name should not be empty or contain '='")
+	ErrorInvalidPortName   = errors.New("Ports name should be unique, not empty and not contain braces")
+	ErrorInvalidCapacity   = errors.New("ConnectionCapacity should be positive")
+	ErrorNegativeValue     = errors.New("negative value in GSExecutables")
+	ErrorInvalidPort       = errors.New("PortCapacity and PortStartFrom should be positive without PortRanges")
+	ErrorInvalidBrainTimer = errors.New("BrainIntervalSec should be positive")
+	ErrorNoLaunchQueue     = errors.New("LaunchQueueSize should be positive with LaunchWaitSec")
+	ErrorNegativeLimit     = errors.New("negative value in process or resource limits")
+	ErrorNoCgroupParent    = errors.New("CgroupParent is required with cgroup limits")
+	ErrorNegativePortValue = errors.New("negative value in port setting")
+	ErrorNegativeLogValue  = errors.New("negative value in GS log setting")
+	ErrorInvalidCrashLoop  = errors.New("CrashLoopWindowSec and CrashLoopBackoffSec should be positive with CrashLoopThreshold")
+	ErrorInvalidAdvertise  = errors.New("AdvertiseURL should be ws, wss, http or https url with host")
+)
+
+var (
+	// Name is used in paths of log files and in urls
+	namePattern      = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
 type GSExecutable struct {
+	// unique and stable identifier used by clients instead of the index,
+	// empty defaults to the file name of ProcessName, with the index
+	// appended when another executable has the name
+	Name               string
 	ProcessName        string
 	ConnectionCapacity int64
 	MaxBackfillSec     int
+	WarmPoolSize       int
+
+	// gs drains up to ShutdownGraceSec after shutdown is requested,
+	// then is killed TerminateTimeoutSec after SIGTERM.
+	// absent means default, zero means SIGTERM or SIGKILL at once
+	ShutdownGraceSec    *int
+	TerminateTimeoutSec *int
+
+	// limits of processes of this executable, zero means unlimited
+	MaxProcesses int
+	MaxStarting  int
+	// expected cost of a process, cores and megabytes
+	ExpectedCPU      float64
+	ExpectedMemoryMB int64
+	// processes exceeding these are killed, zero means unlimited.
+	// MaxCPU is checked over several consecutive samples and processes
+	// exceeding it are shut down gracefully
+	MaxRSSMB   int64
+	MaxCPU     float64
+	MaxFDs     int
+	MaxThreads int
+	// cgroup v2 limits enforced by kernel, cores, megabytes and pids.
+	// zero means unlimited, any of them requires CgroupParent
+	CgroupCPU      float64
+	CgroupMemoryMB int64
+	CgroupPids     int64
+	// launches over limits wait up to LaunchWaitSec when LaunchQueueSize
+	// launches are not waiting already, zero LaunchWaitSec rejects at once
+	LaunchQueueSize int
+	LaunchWaitSec   int
+
+	// dedicated ports only this executable uses,
+	// empty means shared PortRanges
+	PortRanges []PortRange
+	// named port slots allocated together, like game, query and rcon.
+	// empty means a single slot named game
+	Ports []string
+
+	// arguments with placeholders like {port}, see gsparams for the list.
+	// empty means -a {address} -p {port} -u {uuid}
+	Args []string
+	// static arguments appended after Args
+	ExtraArgs []string
+	// environment variables with placeholders in values
+	Env map[string]string
+	// custom values referred as {var.NAME}
+	Vars map[string]string
+	// empty means the working directory of lift
+	WorkDir string
+}
+
+const (
+	DefaultPortName            = "game"
+	DefaultShutdownGraceSec    = 10
+	DefaultTerminateTimeoutSec = 10
+	// output of restorable processes can not go through lift log
+	DefaultGSLogDir = "gslogs"
+)
+
+// PortNames returns names of port slots, the first one is the primary port.
+func (e *GSExecutable) PortNames() []string {
+	if len(e.Ports) == 0 {
+		return []string{DefaultPortName}
+	}
+	return e.Ports
+}
+
+// ShutdownGrace returns ShutdownGraceSec, or default when it is absent.
+func (e *GSExecutable) ShutdownGrace() time.Duration {
+	return secondsOr(e.ShutdownGraceSec, DefaultShutdownGraceSec)
+}
+
+// TerminateTimeout returns TerminateTimeoutSec, or default when it is absent.
+func (e *GSExecutable) TerminateTimeout() time.Duration {
+	return secondsOr(e.TerminateTimeoutSec, DefaultTerminateTimeoutSec)
+}
+
+func secondsOr(sec *int, def int) time.Duration {
+	if sec == nil {
+		return time.Second * time.Duration(def)
+	}
+	return time.Second * time.Duration(*sec)
+}
+
+type APIKey struct {
+	Key  string
+	Role string
+}
+
+type JWTSetting struct {
+	// HS256 or RS256
+	Algorithm     string
+	Secret        string
+	PublicKeyFile string
+	RoleClaim     string
+}
+
+// empty AuthSetting disables authentication
+type AuthSetting struct {
+	APIKeys []APIKey
+	JWT     *JWTSetting
 }
 
 type Setting struct {
@@ -12,14 +157,248 @@ type Setting struct {
 	ServiceName     string
 	ServiceVersion  string
 	ServiceListenAt string
+	// url which gs connects to lift with, like ws://lift.internal:9990.
+	// empty means derived from ServiceListenAt
+	AdvertiseURL string
+
+	Auth AuthSetting
 
 	GSExecutables       []GSExecutable
 	GSListenAddress     string
 	GSMessageTimeoutSec int
 
+	// a range of PortStartFrom and PortCapacity is used without PortRanges
 	PortCapacity  int64
 	PortStartFrom uint16
+	PortRanges    []PortRange
+	// ports never handed out in any range
+	PortExclude []uint16
+	// probe binding on GSListenAddress before handing out a port
+	PortProbeTCP      bool
+	PortProbeUDP      bool
+	PortQuarantineSec int
+	// seconds before a port returned by exited gs is handed out again
+	PortCooldownSec int
 
 	BrainIntervalSec    int
 	BrainMinimumWaitSec int
+
+	// limits of all processes, zero means unlimited
+	MaxProcesses int
+	MaxStarting  int
+	// Retry-After in seconds of launches rejected by limits
+	LaunchRetryAfterSec int
+
+	// zero disables sampling host and processes
+	ResourceSampleSec int
+	// launches are refused when projected usage of the host exceeds
+	// these percents, zero disables
+	HostMaxCPUPercent    float64
+	HostMaxMemoryPercent float64
+
+	// delegated cgroup v2 directory lift creates a cgroup per process in,
+	// like /sys/fs/cgroup/lift
+	CgroupParent string
+
+	// directory output of each process is written to,
+	// empty means output goes to lift log, or DefaultGSLogDir with StateJournalFile
+	GSLogDir string
+	// log file is rotated over the size or the age, zero disables each.
+	// files of processes started with StateJournalFile are not rotated
+	GSLogMaxSizeMB int64
+	GSLogMaxAgeSec int
+	// rotated files kept per process, zero keeps all
+	GSLogMaxBackups int
+	// files of exited processes are deleted after not written for this,
+	// zero keeps them
+	GSLogRetentionSec int
+
+	// an executable is quarantined when CrashLoopThreshold processes fail
+	// within CrashLoopWindowSec, zero threshold disables.
+	// probes are launched after CrashLoopBackoffSec, doubled on each failed
+	// probe up to CrashLoopMaxBackoffSec, zero max means no cap
+	CrashLoopThreshold     int
+	CrashLoopWindowSec     int
+	CrashLoopBackoffSec    int
+	CrashLoopMaxBackoffSec int
+
+	// records of exited processes kept for control api,
+	// zero means default
+	ExitHistorySize int
+
+	// empty disables restoring processes after restart.
+	// when set, processes write output to GSLogDir by themselves so that
+	// they outlive lift, the files are not rotated by size or age
+	StateJournalFile string
+	RestoreGraceSec  int
+}
+
+// SetDefaults fills values which are optional in setting file,
+// it is called before Validate.
+func (s *Setting) SetDefaults() {
+	if s.StateJournalFile != "" && s.GSLogDir == "" {
+		s.GSLogDir = DefaultGSLogDir
+	}
+
+	taken := make(map[string]bool, len(s.GSExecutables))
+	for _, exe := range s.GSExecutables {
+		taken[exe.Name] = true
+	}
+	for i := range s.GSExecutables {
+		exe := &s.GSExecutables[i]
+		if exe.Name != "" || exe.ProcessName == "" {
+			continue
+		}
+
+		name := defaultName(exe.ProcessName)
+		for taken[name] {
+			name += "-" + strconv.Itoa(i)
+		}
+		exe.Name = name
+		taken[name] = true
+	}
+}
+
+// defaultName makes a valid Name from the file name of the process.
+func defaultName(processName string) string {
+	name := invalidNameChars.ReplaceAllString(filepath.Base(processName), "-")
+	if _, err := strconv.Atoi(name); err == nil || strings.Trim(name, ".") == "" {
+		name = "gs-" + name
+	}
+	return name
+}
+
+// Validate checks values which lift can not run with.
+func (s *Setting) Validate() error {
+	if len(s.GSExecutables) == 0 {
+		return ErrorNoExecutable
+	}
+	names := make(map[string]bool, len(s.GSExecutables))
+	for _, exe := range s.GSExecutables {
+		if exe.ProcessName == "" {
+			return ErrorEmptyProcessName
+		}
+		// empty only when SetDefaults was not called
+		if exe.Name == "" {
+			return ErrorEmptyName
+		}
+		if !namePattern.MatchString(exe.Name) || exe.Name == "." || exe.Name == ".." {
+			return ErrorInvalidName
+		}
+		if _, err := strconv.Atoi(exe.Name); err == nil {
+			return ErrorNumericName
+		}
+		if names[exe.Name] {
+			return ErrorDuplicateName
+		}
+		names[exe.Name] = true
+
+		if exe.ConnectionCapacity <= 0 {
+			return ErrorInvalidCapacity
+		}
+		if exe.MaxBackfillSec < 0 ||
+			exe.WarmPoolSize < 0 ||
+			exe.ShutdownGrace() < 0 ||
+			exe.TerminateTimeout() < 0 ||
+			exe.MaxProcesses < 0 ||
+			exe.MaxStarting < 0 ||
+			exe.LaunchQueueSize < 0 ||
+			exe.LaunchWaitSec < 0 ||
+			exe.ExpectedCPU < 0 ||
+			exe.ExpectedMemoryMB < 0 ||
+			exe.MaxRSSMB < 0 ||
+			exe.MaxCPU < 0 ||
+			exe.MaxFDs < 0 ||
+			exe.MaxThreads < 0 ||
+			exe.CgroupCPU < 0 ||
+			exe.CgroupMemoryMB < 0 ||
+			exe.CgroupPids < 0 {
+			return ErrorNegativeValue
+		}
+		if s.CgroupParent == "" &&
+			(exe.CgroupCPU > 0 || exe.CgroupMemoryMB > 0 || exe.CgroupPids > 0) {
+			return ErrorNoCgroupParent
+		}
+		if exe.LaunchWaitSec > 0 && exe.LaunchQueueSize == 0 {
+			return ErrorNoLaunchQueue
+		}
+		portNames := make(map[string]bool, len(exe.Ports))
+		for _, name := range exe.Ports {
+			if name == "" || strings.ContainsAny(name, "{}") || portNames[name] {
+				return ErrorInvalidPortName
+			}
+			portNames[name] = true
+		}
+		for name := range exe.Vars {
+			if name == "" || strings.ContainsAny(name, "{}") {
+				return ErrorInvalidVar
+			}
+		}
+		for name := range exe.Env {
+			if name == "" || strings.Contains(name, "=") {
+				return ErrorInvalidEnv
+			}
+		}
+	}
+
+	if _, err := s.CallbackURL(); err != nil {
+		return err
+	}
+
+	if err := s.validatePorts(); err != nil {
+		return err
+	}
+	if s.PortQuarantineSec < 0 || s.PortCooldownSec < 0 {
+		return ErrorNegativePortValue
+	}
+	if s.GSLogMaxSizeMB < 0 || s.GSLogMaxAgeSec < 0 ||
+		s.GSLogMaxBackups < 0 || s.GSLogRetentionSec < 0 {
+		return ErrorNegativeLogValue
+	}
+	if s.CrashLoopThreshold < 0 || s.CrashLoopMaxBackoffSec < 0 ||
+		(s.CrashLoopThreshold > 0 && (s.CrashLoopWindowSec <= 0 || s.CrashLoopBackoffSec <= 0)) {
+		return ErrorInvalidCrashLoop
+	}
+	if s.BrainIntervalSec <= 0 {
+		return ErrorInvalidBrainTimer
+	}
+	if s.MaxProcesses < 0 || s.MaxStarting < 0 || s.LaunchRetryAfterSec < 0 ||
+		s.ResourceSampleSec < 0 || s.HostMaxCPUPercent < 0 || s.HostMaxMemoryPercent < 0 ||
+		s.ExitHistorySize < 0 {
+		return ErrorNegativeLimit
+	}
+	return nil
+}
+
+// CallbackURL returns base url which gs connects to lift with.
+// wildcard or empty host of ServiceListenAt is advertised as loopback.
+func (s *Setting) CallbackURL() (string, error) {
+	if s.AdvertiseURL == "" {
+		host, port, err := net.SplitHostPort(s.ServiceListenAt)
+		if err != nil {
+			return "", err
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+		return "ws://" + net.JoinHostPort(host, port), nil
+	}
+
+	u, err := url.Parse(s.AdvertiseURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", ErrorInvalidAdvertise
+	}
+	if u.Host == "" {
+		return "", ErrorInvalidAdvertise
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
 }